@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/prometheus"
+	"cscs.ch/hpcdata/util"
+)
+
+// MetricsBackend is the set of telemetry queries the handlers rely on.
+// Every store that we can serve data from (Elasticsearch, OpenSearch, Prometheus, ...) must implement it.
+// The result types are defined in the elastic package, which was the first backend, i.e. every other backend imports
+// the elastic package to return them.
+type MetricsBackend interface {
+	GetJob(ctx context.Context, jobid string, cluster_name string, logger *zerolog.Logger) (*util.Job, error)
+	ListJobs(ctx context.Context, cluster_name string, filter util.JobFilter, logger *zerolog.Logger) (*util.JobList, error)
+	GetGpuTemperature(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.GpuTemperatures, error)
+	GetGlobalFilesystem(ctx context.Context, fs elastic.Filesystem, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.FilesystemStats, error)
+	GetJobFilesystem(ctx context.Context, fs elastic.Filesystem, jobid string, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.JobFilesystemStats, error)
+	GetChassisEnergy(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.ChassisEnergy, error)
+	GetChassisPower(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.ChassisPower, error)
+	GetDcgmData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, metric string, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.DcgmMetric, error)
+	GetDcgmMetricNames(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) ([]string, error)
+	GetMemoryData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.MemoryData, error)
+	GetCpuData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.CpuData, error)
+	GetNetworkData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.NetworkData, error)
+	GetEnergyBreakdown(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*elastic.EnergyBreakdown, error)
+	GetJobSummary(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*elastic.JobSummary, error)
+}
+
+// CatalogBackend is implemented by backends which can serve the metrics declared in the metric catalog of the config file
+type CatalogBackend interface {
+	GetCatalogMetric(ctx context.Context, metric util.CatalogMetric, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.CatalogMetricData, error)
+}
+
+// ensure at compile time that the clients can be used as a backend
+var _ MetricsBackend = (*elastic.Client)(nil)
+var _ CatalogBackend = (*elastic.Client)(nil)
+var _ MetricsBackend = (*prometheus.Client)(nil)
+
+// Backends holds the configured MetricsBackend for every cluster, key==cluster name
+type Backends map[string]MetricsBackend
+
+// NewBackends creates the backend for every cluster in the config, depending on the cluster's `backend` setting.
+// Clusters sharing the same backend configuration share one client.
+func NewBackends(config *util.Config) Backends {
+	ret := Backends{}
+	var esclient, osclient *elastic.Client
+	for _, cc := range config.Clusters {
+		switch cc.Backend {
+		case "", "elastic":
+			if esclient == nil {
+				esclient = elastic.NewClient(config)
+			}
+			ret[cc.Name] = esclient
+		case "opensearch":
+			if osclient == nil {
+				osclient = elastic.NewOpenSearchClient(config)
+			}
+			ret[cc.Name] = osclient
+		case "prometheus":
+			ret[cc.Name] = prometheus.NewClient(cc.Prometheus, config.Timeouts.Prometheus)
+		default:
+			log.Fatalf("Unknown backend=%v for cluster=%v", cc.Backend, cc.Name)
+		}
+	}
+	return ret
+}
+
+func (b Backends) Get(cluster string) (MetricsBackend, error) {
+	if ret, ok := b[cluster]; ok {
+		return ret, nil
+	}
+	return nil, fmt.Errorf("Could not find metrics backend for cluster=%s", cluster)
+}
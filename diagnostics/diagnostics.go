@@ -0,0 +1,230 @@
+// Package diagnostics evaluates rules on the time series of a job and reports inefficient resource usage
+package diagnostics
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/util"
+)
+
+// TimeWindow is a window of the job, during which the condition of a rule holds
+type TimeWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+type Finding struct {
+	Rule     string
+	Severity string
+	Message  string
+	Node     string // empty if the finding concerns the whole job
+	Gpu      *int   // nil if the finding does not concern a single GPU
+	Fraction float64
+	Windows  []TimeWindow
+}
+
+type Result struct {
+	Findings []Finding
+	Warnings []string
+}
+
+// Evaluate queries the series of the job's nodes and evaluates all enabled rules
+func Evaluate(ctx context.Context, metrics backend.MetricsBackend, cfg util.DiagnosticsConfig, cluster *util.ClusterConfig, nodes []util.Node, from, to time.Time, logger *zerolog.Logger) (*Result, error) {
+	ret := Result{Findings: []Finding{}}
+	opts := util.QueryOptions{}
+
+	if !cfg.GpuIdle.Disabled {
+		opts.Agg = "avg"
+		utilization, err := metrics.GetDcgmData(ctx, nodes, from, to, "gpu_utilization", opts, logger)
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting GPU utilization for the diagnostics: %w", err)
+		}
+		ret.add_warnings(utilization.Warnings.Warnings)
+		for node_id, gpus := range utilization.MetricByNode {
+			for _, gpu := range gpus {
+				windows, fraction := windows_where(utilization.Time, to, gpu.Data, func(v float64) bool { return v < cfg.GpuIdle.Threshold })
+				if fraction > cfg.GpuIdle.Fraction {
+					ret.add(cfg.GpuIdle, Finding{
+						Rule:     "gpu_idle",
+						Message:  fmt.Sprintf("GPU %v of %v was idle (utilization below %v%%) during %.0f%% of the runtime", gpu.GpuIndex, node_id, cfg.GpuIdle.Threshold, 100*fraction),
+						Node:     node_id,
+						Gpu:      &gpu.GpuIndex,
+						Fraction: fraction,
+						Windows:  windows,
+					})
+				}
+			}
+		}
+	}
+
+	if !cfg.CpuImbalance.Disabled && len(nodes) > 1 {
+		opts.Agg = "avg"
+		cpu, err := metrics.GetCpuData(ctx, nodes, from, to, opts, logger)
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting cpu data for the diagnostics: %w", err)
+		}
+		ret.add_warnings(cpu.Warnings.Warnings)
+		// the difference of every node to the median of the other nodes, per time bucket
+		busy := map[string][]float64{}
+		for node_id := range cpu.CpuByNode {
+			busy[node_id] = make([]float64, len(cpu.Time))
+		}
+		for idx := range cpu.Time {
+			values := []float64{}
+			for node_id, c := range cpu.CpuByNode {
+				busy[node_id][idx] = c.User[idx] + c.System[idx]
+				if !math.IsNaN(busy[node_id][idx]) {
+					values = append(values, busy[node_id][idx])
+				}
+			}
+			slices.Sort(values)
+			for node_id := range busy {
+				if !math.IsNaN(busy[node_id][idx]) {
+					busy[node_id][idx] -= median_without(values, busy[node_id][idx])
+				}
+			}
+		}
+		for node_id, diff := range busy {
+			windows, fraction := windows_where(cpu.Time, to, diff, func(v float64) bool { return v >= cfg.CpuImbalance.Threshold })
+			if fraction > cfg.CpuImbalance.Fraction {
+				ret.add(cfg.CpuImbalance, Finding{
+					Rule:     "cpu_imbalance",
+					Message:  fmt.Sprintf("The CPU usage of %v exceeded the median of the other nodes by at least %v percentage points during %.0f%% of the runtime", node_id, cfg.CpuImbalance.Threshold, 100*fraction),
+					Node:     node_id,
+					Fraction: fraction,
+					Windows:  windows,
+				})
+			}
+		}
+	}
+
+	if !cfg.MemoryUnderused.Disabled {
+		if cluster.NodeMemory <= 0 {
+			ret.add_warnings([]string{"The rule memory_underused was not evaluated, the node memory of the cluster is not configured"})
+		} else {
+			opts.Agg = ""
+			memory, err := metrics.GetMemoryData(ctx, nodes, from, to, opts, logger)
+			if err != nil {
+				return nil, fmt.Errorf("Failed getting memory data for the diagnostics: %w", err)
+			}
+			ret.add_warnings(memory.Warnings.Warnings)
+			total := cluster.NodeMemory * 1024 * 1024 // the series are in kilobytes
+			peak := math.NaN()
+			for _, m := range memory.MemoryByNode {
+				for idx := range m.Free {
+					used := 100 * (total - m.Free[idx] - m.Cache[idx] - m.Buffer[idx]) / total
+					if !math.IsNaN(used) && (math.IsNaN(peak) || used > peak) {
+						peak = used
+					}
+				}
+			}
+			if !math.IsNaN(peak) && peak < cfg.MemoryUnderused.Threshold {
+				ret.add(cfg.MemoryUnderused, Finding{
+					Rule:    "memory_underused",
+					Message: fmt.Sprintf("The job used at most %.1f%% of the nodes' memory, it might fit on fewer nodes", peak),
+					Windows: []TimeWindow{{from, to}},
+				})
+			}
+		}
+	}
+
+	if !cfg.GpuTemperature.Disabled {
+		opts.Agg = "max"
+		temperature, err := metrics.GetGpuTemperature(ctx, nodes, from, to, opts, logger)
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting GPU temperature for the diagnostics: %w", err)
+		}
+		ret.add_warnings(temperature.Warnings.Warnings)
+		for node_id, gpus := range temperature.Temperatures {
+			for _, gpu := range gpus {
+				windows, fraction := windows_where(temperature.Time, to, gpu.Temperatures, func(v float64) bool { return v >= cfg.GpuTemperature.Threshold })
+				if len(windows) > 0 && fraction >= cfg.GpuTemperature.Fraction {
+					ret.add(cfg.GpuTemperature, Finding{
+						Rule:     "gpu_temperature",
+						Message:  fmt.Sprintf("GPU %v of %v reached %v°C or more, it might have been throttled", gpu.GpuIndex, node_id, cfg.GpuTemperature.Threshold),
+						Node:     node_id,
+						Gpu:      &gpu.GpuIndex,
+						Fraction: fraction,
+						Windows:  windows,
+					})
+				}
+			}
+		}
+	}
+
+	slices.SortStableFunc(ret.Findings, func(a, b Finding) int {
+		// most severe first
+		return cmp.Or(
+			cmp.Compare(slices.Index(util.DiagnosticSeverities, b.Severity), slices.Index(util.DiagnosticSeverities, a.Severity)),
+			cmp.Compare(a.Rule, b.Rule),
+			cmp.Compare(a.Node, b.Node),
+		)
+	})
+	return &ret, nil
+}
+
+func (r *Result) add(rule util.DiagnosticRule, f Finding) {
+	f.Severity = rule.Severity
+	r.Findings = append(r.Findings, f)
+}
+
+func (r *Result) add_warnings(warnings []string) {
+	for _, w := range warnings {
+		if !slices.Contains(r.Warnings, w) {
+			r.Warnings = append(r.Warnings, w)
+		}
+	}
+}
+
+// windows_where returns the time windows during which the condition holds and their fraction of all time buckets.
+// Time buckets without data (NaN) never fulfill the condition, the last time bucket ends at `to`.
+func windows_where(timeline []time.Time, to time.Time, data []float64, condition func(float64) bool) ([]TimeWindow, float64) {
+	ret := []TimeWindow{}
+	count := 0
+	for idx, v := range data {
+		if math.IsNaN(v) || !condition(v) {
+			continue
+		}
+		count++
+		end := to
+		if idx+1 < len(timeline) {
+			end = timeline[idx+1]
+		}
+		if len(ret) > 0 && ret[len(ret)-1].To.Equal(timeline[idx]) {
+			ret[len(ret)-1].To = end
+		} else {
+			ret = append(ret, TimeWindow{timeline[idx], end})
+		}
+	}
+	if len(data) == 0 {
+		return ret, 0
+	}
+	return ret, float64(count) / float64(len(data))
+}
+
+// the median of the sorted values, after removing one occurrence of v
+func median_without(sorted []float64, v float64) float64 {
+	n := len(sorted) - 1
+	if n <= 0 {
+		return math.NaN()
+	}
+	skip, _ := slices.BinarySearch(sorted, v)
+	at := func(idx int) float64 {
+		if idx >= skip {
+			idx++
+		}
+		return sorted[idx]
+	}
+	if n%2 == 1 {
+		return at(n / 2)
+	}
+	return (at(n/2-1) + at(n/2)) / 2
+}
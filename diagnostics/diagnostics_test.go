@@ -0,0 +1,70 @@
+package diagnostics
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWindowsWhere(t *testing.T) {
+	nan := math.NaN()
+	start := time.Unix(1700000000, 0)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	timeline := []time.Time{at(0), at(1), at(2), at(3), at(4)}
+	to := at(4).Add(30 * time.Second)
+	below := func(v float64) bool { return v < 10 }
+
+	tests := []struct {
+		name     string
+		timeline []time.Time
+		data     []float64
+		windows  []TimeWindow
+		fraction float64
+	}{
+		{"never", timeline, []float64{10, 20, 30, 40, 50}, []TimeWindow{}, 0},
+		{"always", timeline, []float64{1, 2, 3, 4, 5}, []TimeWindow{{at(0), to}}, 1},
+		{"adjacent buckets are merged", timeline, []float64{1, 2, 30, 4, 50}, []TimeWindow{{at(0), at(2)}, {at(3), at(4)}}, 0.6},
+		{"the last bucket ends at to", timeline, []float64{10, 20, 30, 40, 5}, []TimeWindow{{at(4), to}}, 0.2},
+		{"no data splits a window", timeline, []float64{1, nan, 3, nan, nan}, []TimeWindow{{at(0), at(1)}, {at(2), at(3)}}, 0.4},
+		{"empty", []time.Time{}, []float64{}, []TimeWindow{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, fraction := windows_where(tt.timeline, to, tt.data, below)
+			if !slices.EqualFunc(windows, tt.windows, func(a, b TimeWindow) bool { return a.From.Equal(b.From) && a.To.Equal(b.To) }) {
+				t.Errorf("got windows %v, expected %v", windows, tt.windows)
+			}
+			if fraction != tt.fraction {
+				t.Errorf("got fraction %v, expected %v", fraction, tt.fraction)
+			}
+		})
+	}
+}
+
+func TestMedianWithout(t *testing.T) {
+	tests := []struct {
+		name     string
+		sorted   []float64
+		v        float64
+		expected float64
+	}{
+		{"odd remaining", []float64{1, 2, 3, 4}, 4, 2},
+		{"even remaining", []float64{1, 2, 3, 4, 5}, 1, 3.5},
+		{"skip in the middle", []float64{1, 2, 3, 4, 5}, 3, 3},
+		{"skip the first", []float64{1, 5, 6}, 1, 5.5},
+		{"duplicates of the skipped value", []float64{1, 2, 2, 2, 9}, 2, 2},
+		{"duplicates around the median", []float64{1, 3, 3, 8}, 3, 3},
+		{"all equal", []float64{7, 7, 7}, 7, 7},
+		{"single value", []float64{1}, 1, math.NaN()},
+		{"empty", []float64{}, 1, math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := median_without(tt.sorted, tt.v)
+			if got != tt.expected && !(math.IsNaN(got) && math.IsNaN(tt.expected)) {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
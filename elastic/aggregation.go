@@ -0,0 +1,61 @@
+package elastic
+
+import (
+	"encoding/json"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/sortorder"
+)
+
+// value_aggregation aggregates field within a time bucket.
+// aggregation is one of max, min, avg, sum, p95 or last (see util.Aggregations), the default is max
+func value_aggregation(aggregation, field string) types.Aggregations {
+	switch aggregation {
+	case "min":
+		return types.Aggregations{Min: &types.MinAggregation{Field: ptr(field)}}
+	case "avg":
+		return types.Aggregations{Avg: &types.AverageAggregation{Field: ptr(field)}}
+	case "sum":
+		return types.Aggregations{Sum: &types.SumAggregation{Field: ptr(field)}}
+	case "p95":
+		return types.Aggregations{Percentiles: &types.PercentilesAggregation{Field: ptr(field), Percents: []types.Float64{95}, Keyed: ptr(false)}}
+	case "last":
+		// top_metrics would be simpler, but it is not available in OpenSearch
+		return types.Aggregations{TopHits: &types.TopHitsAggregation{
+			Size:           ptr(1),
+			Sort:           []types.SortCombinations{types.SortOptions{SortOptions: map[string]types.FieldSort{"@timestamp": {Order: &sortorder.Desc}}}},
+			Source_:        false,
+			DocvalueFields: []types.FieldAndFormat{{Field: field}},
+		}}
+	default:
+		return types.Aggregations{Max: &types.MaxAggregation{Field: ptr(field)}}
+	}
+}
+
+// the value of an aggregate created by value_aggregation, nil if the bucket has no value
+func aggregate_value(agg types.Aggregate) *types.Float64 {
+	switch a := agg.(type) {
+	case *types.MaxAggregate:
+		return a.Value
+	case *types.MinAggregate:
+		return a.Value
+	case *types.AvgAggregate:
+		return a.Value
+	case *types.SumAggregate:
+		return a.Value
+	case *types.TDigestPercentilesAggregate:
+		if items, ok := a.Values.([]types.ArrayPercentilesItem); ok && len(items) > 0 {
+			return items[0].Value
+		}
+	case *types.TopHitsAggregate:
+		for _, hit := range a.Hits.Hits {
+			for _, raw := range hit.Fields {
+				values := []types.Float64{}
+				if err := json.Unmarshal(raw, &values); err == nil && len(values) > 0 {
+					return &values[0]
+				}
+			}
+		}
+	}
+	return nil
+}
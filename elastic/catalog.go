@@ -0,0 +1,189 @@
+package elastic
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/rs/zerolog"
+
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+type CatalogSeries struct {
+	Split string // value of the metric's split_field, empty if the metric has no split_field
+	Data  []float64
+}
+type CatalogMetricData struct {
+	Warnings
+	Time []time.Time
+	// key==node_id, without a split_field there is exactly one series per node
+	MetricByNode map[string][]CatalogSeries
+}
+
+// GetCatalogMetric queries a metric declared in the metric catalog of the config file
+func (c *Client) GetCatalogMetric(ctx context.Context, metric util.CatalogMetric, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*CatalogMetricData, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	interval := get_interval(from, to, metric.MinInterval, opts)
+	buckets_per_node := 1
+	if metric.SplitField != "" {
+		buckets_per_node = 5
+	}
+	return query_chunked(ctx, c, nodes, from, to, interval, buckets_per_node, func(ctx context.Context, nodes []util.Node) (*CatalogMetricData, error) {
+		return c.get_catalog_metric(ctx, metric, nodes, from, to, interval, opts, logger)
+	})
+}
+
+func (c *Client) get_catalog_metric(ctx context.Context, metric util.CatalogMetric, nodes []util.Node, from time.Time, to time.Time, interval time.Duration, opts util.QueryOptions, logger *zerolog.Logger) (*CatalogMetricData, error) {
+	nodesOfInterest := []string{}
+	for _, n := range nodes {
+		if metric.NodeIdNumeric {
+			n1, _ := strings.CutPrefix(n.Nid, "nid")
+			nodesOfInterest = append(nodesOfInterest, strings.TrimLeft(n1, "0"))
+		} else {
+			nodesOfInterest = append(nodesOfInterest, n.Nid)
+		}
+	}
+
+	filter := []types.Query{
+		{
+			Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{metric.NodeField: nodesOfInterest}},
+		}, {
+			Range: map[string]types.RangeQuery{
+				"@timestamp": types.DateRangeQuery{
+					Format: ptr("epoch_second"),
+					Gte:    ptr(strconv.FormatInt(from.Unix(), 10)),
+					Lt:     ptr(strconv.FormatInt(to.Unix(), 10)),
+				},
+			},
+		},
+	}
+	filterFields := []string{}
+	for field := range metric.Filter {
+		filterFields = append(filterFields, field)
+	}
+	slices.Sort(filterFields)
+	for _, field := range filterFields {
+		filter = append(filter, types.Query{Term: map[string]types.TermQuery{field: {Value: metric.Filter[field]}}})
+	}
+
+	valueAggregation := map[string]types.Aggregations{"value": value_aggregation(cmp.Or(opts.Agg, metric.Aggregation), metric.ValueField)}
+	nodeAggregation := types.Aggregations{
+		Terms: &types.TermsAggregation{
+			Size:  ptr(len(nodesOfInterest)),
+			Field: ptr(metric.NodeField),
+		},
+		Aggregations: valueAggregation,
+	}
+	if metric.SplitField != "" {
+		nodeAggregation.Aggregations = map[string]types.Aggregations{
+			"split": {
+				Terms:        &types.TermsAggregation{Field: ptr(metric.SplitField)},
+				Aggregations: valueAggregation,
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	res, err := c.Search().
+		Index(metric.Index).
+		Request(&search.Request{
+			Size: ptr(0), // we are only interested in the aggregation results
+			Query: &types.Query{
+				Bool: &types.BoolQuery{
+					Filter: filter,
+				},
+			},
+			Aggregations: map[string]types.Aggregations{
+				"timestamps": {
+					DateHistogram: &types.DateHistogramAggregation{
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
+					},
+					Aggregations: map[string]types.Aggregations{
+						"nodes": nodeAggregation,
+					},
+				},
+			},
+		}).Do(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting catalog metric %v, when searching in elastic: %w", metric.Path, err)
+	}
+
+	timestampBuckets := res.Aggregations["timestamps"].(*types.DateHistogramAggregate).Buckets.([]types.DateHistogramBucket)
+	logger.Debug().Msgf("Querying catalog metric %v from elastic took %vms. Num results in aggregation=%v", metric.Path, res.Took, len(timestampBuckets))
+	if len(timestampBuckets) > 0 {
+		logger.Debug().Msgf("First bucket result: %+v", timestampBuckets[0])
+	}
+
+	ret := CatalogMetricData{MetricByNode: map[string][]CatalogSeries{}}
+	for _, timestampBucket := range timestampBuckets {
+		ret.Time = append(ret.Time, time.Unix(timestampBucket.Key/1000, 0))
+		// append to every already known series a NaN, such that it will have in the end the same length as the time array
+		for _, series := range ret.MetricByNode {
+			for i := range series {
+				series[i].Data = append(series[i].Data, math.NaN())
+			}
+		}
+		ret.check_truncated(timestampBucket.Aggregations["nodes"], "nodes")
+		for _, nodeBucket := range terms_buckets(timestampBucket.Aggregations["nodes"]) {
+			node_id := nodeBucket.key
+			if metric.NodeIdNumeric {
+				node_id = "nid" + strings.Repeat("0", 6-len(node_id)) + node_id
+			}
+			splitBuckets := []termsBucket{{"", nodeBucket.aggregations}}
+			if metric.SplitField != "" {
+				ret.check_truncated(nodeBucket.aggregations["split"], metric.SplitField+" values")
+				splitBuckets = terms_buckets(nodeBucket.aggregations["split"])
+			}
+			for _, splitBucket := range splitBuckets {
+				idx := slices.IndexFunc(ret.MetricByNode[node_id], func(s CatalogSeries) bool { return s.Split == splitBucket.key })
+				if idx == -1 {
+					ret.MetricByNode[node_id] = append(ret.MetricByNode[node_id], CatalogSeries{Split: splitBucket.key, Data: NewSeries(len(ret.Time))})
+					idx = len(ret.MetricByNode[node_id]) - 1
+				}
+				ret.MetricByNode[node_id][idx].Data[len(ret.Time)-1] = value_or_missing(aggregate_value(splitBucket.aggregations["value"]))
+			}
+		}
+	}
+	return &ret, nil
+}
+
+type termsBucket struct {
+	key          string
+	aggregations map[string]types.Aggregate
+}
+
+// the buckets of a terms aggregate, independent of whether the field is a keyword or a number
+func terms_buckets(agg types.Aggregate) []termsBucket {
+	ret := []termsBucket{}
+	switch a := agg.(type) {
+	case *types.StringTermsAggregate:
+		for _, b := range a.Buckets.([]types.StringTermsBucket) {
+			ret = append(ret, termsBucket{fmt.Sprint(b.Key), b.Aggregations})
+		}
+	case *types.LongTermsAggregate:
+		for _, b := range a.Buckets.([]types.LongTermsBucket) {
+			ret = append(ret, termsBucket{strconv.FormatInt(b.Key, 10), b.Aggregations})
+		}
+	case *types.DoubleTermsAggregate:
+		for _, b := range a.Buckets.([]types.DoubleTermsBucket) {
+			ret = append(ret, termsBucket{strconv.FormatFloat(float64(b.Key), 'f', -1, 64), b.Aggregations})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,184 @@
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+
+	"cscs.ch/hpcdata/util"
+)
+
+// default of the elasticsearch setting search.max_buckets
+const default_max_buckets = 65536
+
+// number of chunks of a large job that are queried at the same time
+const max_parallel_chunks = 4
+
+// Warnings is embedded in the query results and collects reasons why a result might be incomplete
+type Warnings struct {
+	Warnings []string
+}
+
+func (w *Warnings) warn(format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	if !slices.Contains(w.Warnings, msg) {
+		w.Warnings = append(w.Warnings, msg)
+	}
+}
+
+func (w *Warnings) add_warnings(other Warnings) {
+	for _, msg := range other.Warnings {
+		w.warn("%s", msg)
+	}
+}
+
+// add a warning if a terms aggregation did not return all terms
+func (w *Warnings) check_truncated(agg types.Aggregate, what string) {
+	var sumOtherDocCount *int64
+	switch a := agg.(type) {
+	case *types.StringTermsAggregate:
+		sumOtherDocCount = a.SumOtherDocCount
+	case *types.LongTermsAggregate:
+		sumOtherDocCount = a.SumOtherDocCount
+	case *types.DoubleTermsAggregate:
+		sumOtherDocCount = a.SumOtherDocCount
+	}
+	if sumOtherDocCount != nil && *sumOtherDocCount > 0 {
+		w.warn("The result is truncated, not all %v were returned", what)
+	}
+}
+
+// a query result that can be combined with the result of the same query for other nodes
+type chunkResult[T any] interface {
+	*T
+	merge(other *T)
+}
+
+// query_chunked splits the nodes into chunks, such that a single query does not exceed max_buckets buckets, queries
+// the chunks in parallel and merges the results.
+// buckets_per_node is the number of buckets that a node creates in every time bucket (including the node's bucket itself).
+// Returns util.ErrInvalidInput if not even the query of a single node fits, i.e. the time window has too many time buckets.
+func query_chunked[T any, PT chunkResult[T]](ctx context.Context, c *Client, nodes []util.Node, from, to time.Time, interval time.Duration, buckets_per_node int, query func(ctx context.Context, nodes []util.Node) (PT, error)) (PT, error) {
+	num_time_buckets := count_time_buckets(from, to, interval)
+	chunk_size := (c.maxBuckets - num_time_buckets) / (num_time_buckets * buckets_per_node)
+	if chunk_size < 1 {
+		return nil, too_many_time_buckets(num_time_buckets, c.maxBuckets/(1+buckets_per_node))
+	}
+	if len(nodes) <= chunk_size {
+		return query(ctx, nodes)
+	}
+
+	// the first failing chunk cancels all other chunks
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	chunks := [][]util.Node{}
+	for chunk := range slices.Chunk(nodes, chunk_size) {
+		chunks = append(chunks, chunk)
+	}
+	results := make([]PT, len(chunks))
+	semaphore := make(chan struct{}, max_parallel_chunks)
+	var wg sync.WaitGroup
+	for idx, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-semaphore }()
+			var err error
+			if results[idx], err = query(ctx, chunk); err != nil {
+				cancel(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	for _, result := range results[1:] {
+		results[0].merge(result)
+	}
+	return results[0], nil
+}
+
+// the number of time buckets of a date histogram with histogram_bounds
+func count_time_buckets(from, to time.Time, interval time.Duration) int {
+	return int(math.Ceil(to.Sub(from).Seconds()/interval.Seconds())) + 1
+}
+
+// the error of a query that exceeds max_buckets with its time buckets alone, splitting it by nodes does not help
+func too_many_time_buckets(num_time_buckets, max_time_buckets int) error {
+	return fmt.Errorf("The time window has %v time buckets, but at most %v can be queried. Select a larger `step` or fewer `points` - %w", num_time_buckets, max_time_buckets, util.ErrInvalidInput)
+}
+
+// the bounds of a date histogram, such that all queries for the same time window return the same buckets,
+// independent of which nodes have data
+func histogram_bounds(from, to time.Time) *types.ExtendedBoundsFieldDateMath {
+	if !to.After(from) {
+		return nil
+	}
+	return &types.ExtendedBoundsFieldDateMath{Min: from.UnixMilli(), Max: to.UnixMilli() - 1}
+}
+
+func (d *GpuTemperatures) merge(other *GpuTemperatures) {
+	merge_time(&d.Time, other.Time)
+	merge_nodes(d.Temperatures, other.Temperatures)
+	d.add_warnings(other.Warnings)
+}
+func (d *ChassisEnergy) merge(other *ChassisEnergy) {
+	merge_time(&d.Time, other.Time)
+	merge_nodes(d.EnergyByNode, other.EnergyByNode)
+	d.add_warnings(other.Warnings)
+}
+func (d *ChassisPower) merge(other *ChassisPower) {
+	merge_time(&d.Time, other.Time)
+	merge_nodes(d.PowerByNode, other.PowerByNode)
+	d.add_warnings(other.Warnings)
+}
+func (d *DcgmMetric) merge(other *DcgmMetric) {
+	merge_time(&d.Time, other.Time)
+	merge_nodes(d.MetricByNode, other.MetricByNode)
+	d.add_warnings(other.Warnings)
+}
+func (d *MemoryData) merge(other *MemoryData) {
+	merge_time(&d.Time, other.Time)
+	merge_nodes(d.MemoryByNode, other.MemoryByNode)
+	d.add_warnings(other.Warnings)
+}
+func (d *CpuData) merge(other *CpuData) {
+	merge_time(&d.Time, other.Time)
+	merge_nodes(d.CpuByNode, other.CpuByNode)
+	d.add_warnings(other.Warnings)
+}
+func (d *NetworkData) merge(other *NetworkData) {
+	merge_time(&d.Time, other.Time)
+	merge_nodes(d.CountersByNode, other.CountersByNode)
+	d.add_warnings(other.Warnings)
+}
+func (d *CatalogMetricData) merge(other *CatalogMetricData) {
+	merge_time(&d.Time, other.Time)
+	merge_nodes(d.MetricByNode, other.MetricByNode)
+	d.add_warnings(other.Warnings)
+}
+
+// all chunks have the same time buckets (see histogram_bounds), only a chunk without any data has no buckets at all
+func merge_time(into *[]time.Time, other []time.Time) {
+	if len(*into) == 0 {
+		*into = other
+	}
+}
+
+func merge_nodes[V any](into map[string]V, other map[string]V) {
+	for node_id, v := range other {
+		into[node_id] = v
+	}
+}
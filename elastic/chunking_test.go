@@ -0,0 +1,87 @@
+package elastic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"cscs.ch/hpcdata/util"
+)
+
+// the nodes of all chunks that were queried
+type chunkedNodes struct {
+	nodes []string
+}
+
+func (d *chunkedNodes) merge(other *chunkedNodes) {
+	d.nodes = append(d.nodes, other.nodes...)
+}
+
+func TestQueryChunkedAtMaxPoints(t *testing.T) {
+	// handler.max_points, the finest time resolution a client can request
+	const max_points = 10000
+	interval := 30 * time.Second
+	from := time.Unix(1700000000, 0)
+	to := from.Add(max_points * interval)
+	num_time_buckets := max_points + 1
+
+	nodes := []util.Node{}
+	for idx := range 1000 {
+		nodes = append(nodes, util.Node{Nid: fmt.Sprintf("nid%06d", idx)})
+	}
+
+	tests := []struct {
+		name             string
+		buckets_per_node int
+		max_buckets      int
+		fits             bool
+	}{
+		{"power", 1, default_max_buckets, true},
+		{"cpu", 3, default_max_buckets, true},
+		{"gpu temperature", 5, default_max_buckets, true},
+		{"summary", 9, default_max_buckets, false},
+		{"network", 29, default_max_buckets, false},
+		{"network with larger max_buckets", 29, 1 << 20, true},
+		{"max_buckets below the time buckets", 1, max_points, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{maxBuckets: tt.max_buckets}
+			var mutex sync.Mutex
+			queries := 0
+			ret, err := query_chunked(context.Background(), c, nodes, from, to, interval, tt.buckets_per_node, func(ctx context.Context, chunk []util.Node) (*chunkedNodes, error) {
+				if buckets := num_time_buckets * (1 + len(chunk)*tt.buckets_per_node); buckets > tt.max_buckets {
+					t.Errorf("a chunk of %v nodes creates %v buckets, more than %v", len(chunk), buckets, tt.max_buckets)
+				}
+				mutex.Lock()
+				defer mutex.Unlock()
+				queries++
+				ret := chunkedNodes{}
+				for _, n := range chunk {
+					ret.nodes = append(ret.nodes, n.Nid)
+				}
+				return &ret, nil
+			})
+			if !tt.fits {
+				if !errors.Is(err, util.ErrInvalidInput) || queries > 0 {
+					t.Fatalf("expected util.ErrInvalidInput without any query, got err=%v after %v queries", err, queries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(ret.nodes)
+			if !slices.EqualFunc(ret.nodes, nodes, func(nid string, n util.Node) bool { return nid == n.Nid }) {
+				t.Errorf("the chunks did not query every node exactly once")
+			}
+			if queries < 2 {
+				t.Errorf("expected the nodes to be split into chunks, got %v queries", queries)
+			}
+		})
+	}
+}
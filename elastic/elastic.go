@@ -1,6 +1,7 @@
 package elastic
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -14,7 +15,7 @@ import (
 	es "github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
-	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/calendarinterval"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/sortorder"
 
 	"github.com/rs/zerolog"
 
@@ -26,13 +27,18 @@ type Filesystem string
 
 const (
 	Capstor  Filesystem = "CAPSTOR"
-	Iopsstor            = "IOPSSTOR"
+	Iopsstor Filesystem = "IOPSSTOR"
 )
 
 const wanted_num_timestamps = 5000
 
+// Client runs the telemetry queries. Search creates the search request and is bound to the transport of the
+// Elasticsearch (see NewClient) or OpenSearch (see NewOpenSearchClient) cluster
 type Client struct {
-	*es.TypedClient
+	Search search.NewSearch
+	// maximum number of buckets that a single search may return, larger jobs are queried in chunks of nodes
+	maxBuckets int
+	timeout    time.Duration // of a single search
 }
 
 func NewClient(config *util.Config) *Client {
@@ -46,25 +52,34 @@ func NewClient(config *util.Config) *Client {
 	if err != nil {
 		panic("Failed creating ElasticClient")
 	}
-	return &Client{c}
+	return &Client{c.Search, max_buckets(config.Elastic), config.Timeouts.Elastic}
 }
 
-func (c *Client) GetJob(jobid string, cluster_name string, logger *zerolog.Logger) (*util.Job, error) {
+func (c *Client) GetJob(ctx context.Context, jobid string, cluster_name string, logger *zerolog.Logger) (*util.Job, error) {
 	if logger == nil {
 		logger = logging.Get()
 	}
+	jobQuery, err := job_id_query(jobid)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	res, err := c.Search().
 		Index(".ds-logs-slurm.accounting-*").
 		Request(&search.Request{
+			Size: ptr(max_job_allocations),
+			Sort: []types.SortCombinations{types.SortOptions{SortOptions: map[string]types.FieldSort{"@start": {Order: &sortorder.Asc}}}},
 			Query: &types.Query{
 				Bool: &types.BoolQuery{
 					Filter: []types.Query{
-						{Term: map[string]types.TermQuery{"jobid": {Value: jobid}}},
+						jobQuery,
 						{Term: map[string]types.TermQuery{"cluster": {Value: cluster_name}}},
 					},
 				},
 			},
-		}).Do(context.Background())
+		}).Do(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("Failed searching in elastic: %w", err)
 	}
@@ -73,20 +88,147 @@ func (c *Client) GetJob(jobid string, cluster_name string, logger *zerolog.Logge
 	if res.Hits.Total.Value == 0 {
 		return nil, fmt.Errorf("No job found - %w", util.ErrInvalidInput)
 	}
-	if res.Hits.Total.Value > 1 {
-		logger.Error().Msgf("Found more than one matching job for cluster=%v and jobid=%v", cluster_name, jobid)
+	if res.Hits.Total.Value > int64(len(res.Hits.Hits)) {
+		logger.Error().Msgf("Found %v allocations for cluster=%v and jobid=%v, only the first %v are used", res.Hits.Total.Value, cluster_name, jobid, len(res.Hits.Hits))
 		// do not fail
 	}
+	// a job array, a heterogeneous job or a requeued job has several allocations
+	allocations := []util.Job{}
+	for _, hit := range res.Hits.Hits {
+		logger.Debug().Msgf("elastic job json=%v", string(hit.Source_))
+		job, err := parse_job(hit.Source_)
+		if err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, *job)
+	}
+	return util.CombineJobs(jobid, allocations), nil
+}
+
+// the maximum number of allocations of a job id, e.g. the tasks of a job array
+const max_job_allocations = 1000
+
+// the query for the allocations of a job id, which is either
+//   - a job id (e.g. 1234), which also matches all tasks of a job array and all components of a heterogeneous job
+//   - a task of a job array (e.g. 1234_7)
+//   - a component of a heterogeneous job (e.g. 1234+1)
+func job_id_query(jobid string) (types.Query, error) {
+	term := func(field, value string) types.Query {
+		return types.Query{Term: map[string]types.TermQuery{field: {Value: value}}}
+	}
+	is_number := func(s string) bool {
+		_, err := strconv.ParseUint(s, 10, 64)
+		return err == nil
+	}
+	if array_job_id, task_id, found := strings.Cut(jobid, "_"); found && is_number(array_job_id) && is_number(task_id) {
+		return types.Query{Bool: &types.BoolQuery{Filter: []types.Query{term("array_job_id", array_job_id), term("array_task_id", task_id)}}}, nil
+	}
+	if het_job_id, offset, found := strings.Cut(jobid, "+"); found && is_number(het_job_id) && is_number(offset) {
+		return types.Query{Bool: &types.BoolQuery{Filter: []types.Query{term("het_job_id", het_job_id), term("het_job_offset", offset)}}}, nil
+	}
+	if is_number(jobid) {
+		return types.Query{Bool: &types.BoolQuery{
+			Should:             []types.Query{term("jobid", jobid), term("array_job_id", jobid), term("het_job_id", jobid)},
+			MinimumShouldMatch: 1,
+		}}, nil
+	}
+	return types.Query{}, fmt.Errorf("The job id %v is invalid, it must be e.g. 1234, 1234_7 or 1234+1 - %w", jobid, util.ErrInvalidInput)
+}
+
+// the elasticsearch setting index.max_result_window, the maximum of from + size of a search
+const max_result_window = 10000
+
+// ListJobs returns the jobs of the cluster matching the filter, the most recently started first
+func (c *Client) ListJobs(ctx context.Context, cluster_name string, filter util.JobFilter, logger *zerolog.Logger) (*util.JobList, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+	if filter.Offset+filter.Limit > max_result_window {
+		return nil, fmt.Errorf("Only the first %v jobs can be listed, narrow the time window - %w", max_result_window, util.ErrInvalidInput)
+	}
+	query := []types.Query{
+		{Term: map[string]types.TermQuery{"cluster": {Value: cluster_name}}},
+		{
+			Range: map[string]types.RangeQuery{
+				"@start": types.DateRangeQuery{Format: ptr("epoch_second"), Lt: ptr(strconv.FormatInt(filter.To.Unix(), 10))},
+			},
+		}, {
+			Range: map[string]types.RangeQuery{
+				"@end": types.DateRangeQuery{Format: ptr("epoch_second"), Gte: ptr(strconv.FormatInt(filter.From.Unix(), 10))},
+			},
+		},
+	}
+	if filter.Accounts != nil {
+		// the accounting stores the submission account with the prefix `a-`
+		accounts := []string{}
+		for _, account := range filter.Accounts {
+			accounts = append(accounts, account, "a-"+account)
+		}
+		query = append(query, types.Query{Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"account": accounts}}})
+	}
+	if filter.User != "" {
+		query = append(query, types.Query{Term: map[string]types.TermQuery{"username": {Value: filter.User}}})
+	}
+	exclude := []types.Query{}
+	if len(filter.Exclude) > 0 {
+		exclude = append(exclude, types.Query{Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"jobid": filter.Exclude}}})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	res, err := c.Search().
+		Index(".ds-logs-slurm.accounting-*").
+		Request(&search.Request{
+			From:           ptr(filter.Offset),
+			Size:           ptr(filter.Limit),
+			TrackTotalHits: true,
+			Sort:           []types.SortCombinations{types.SortOptions{SortOptions: map[string]types.FieldSort{"@start": {Order: &sortorder.Desc}}}},
+			Query: &types.Query{
+				Bool: &types.BoolQuery{
+					Filter:  query,
+					MustNot: exclude,
+				},
+			},
+		}).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed searching jobs in elastic: %w", err)
+	}
+	logger.Debug().Msgf("Querying jobs from elastic took %vms. Num results found=%v", res.Took, res.Hits.Total.Value)
+
+	ret := util.JobList{Jobs: []util.Job{}, Total: int(res.Hits.Total.Value)}
+	for _, hit := range res.Hits.Hits {
+		job, err := parse_job(hit.Source_)
+		if err != nil {
+			return nil, err
+		}
+		ret.Jobs = append(ret.Jobs, *job)
+	}
+	return &ret, nil
+}
+
+// parse a document of the slurm accounting index
+func parse_job(source json.RawMessage) (*util.Job, error) {
 	type ElasticJob struct {
-		Account string `json:"account"`
-		JobId   int    `json:"jobid"`
-		Start   string `json:"@start"`
-		End     string `json:"@end"`
-		Nodes   string `json:"nodes"`
+		Account    string          `json:"account"`
+		JobId      int             `json:"jobid"`
+		Start      string          `json:"@start"`
+		End        string          `json:"@end"`
+		Nodes      string          `json:"nodes"`
+		Name       string          `json:"job_name"`
+		User       string          `json:"username"`
+		Partition  string          `json:"partition"`
+		State      string          `json:"state"`
+		ExitCode   string          `json:"exit_code"` // <exit code>:<signal>
+		WorkingDir string          `json:"work_dir"`
+		TimeLimit  json.RawMessage `json:"time_limit"` // in minutes, or "UNLIMITED"
+		// set for the tasks of a job array and the components of a heterogeneous job
+		ArrayJobId   *int `json:"array_job_id"`
+		ArrayTaskId  *int `json:"array_task_id"`
+		HetJobId     *int `json:"het_job_id"`
+		HetJobOffset *int `json:"het_job_offset"`
 	}
 	var elasticJob ElasticJob
-	logger.Debug().Msgf("elastic job json=%v", string(res.Hits.Hits[len(res.Hits.Hits)-1].Source_))
-	err = json.Unmarshal(res.Hits.Hits[len(res.Hits.Hits)-1].Source_, &elasticJob)
+	err := json.Unmarshal(source, &elasticJob)
 	if err != nil {
 		return nil, fmt.Errorf("Failed json unpacking of elastic hit: %w", err)
 	}
@@ -101,14 +243,32 @@ func (c *Client) GetJob(jobid string, cluster_name string, logger *zerolog.Logge
 		return nil, fmt.Errorf("Failed parsing end date: %w", err)
 	}
 
-	return &util.Job{
-		SlurmId:  fmt.Sprintf("%v", elasticJob.JobId),
-		Account:  submission_account,
-		Start:    start,
-		End:      end,
-		Nodes:    util.ExpandNodes(elasticJob.Nodes),
-		Finished: true, // a job in elastic is only pushed after it has finished
-	}, nil
+	ret := util.Job{
+		SlurmId:    fmt.Sprintf("%v", elasticJob.JobId),
+		Account:    submission_account,
+		Start:      start,
+		End:        end,
+		Nodes:      util.ExpandNodes(elasticJob.Nodes),
+		Finished:   true, // a job in elastic is only pushed after it has finished
+		Name:       elasticJob.Name,
+		User:       elasticJob.User,
+		Partition:  elasticJob.Partition,
+		State:      elasticJob.State,
+		WorkingDir: elasticJob.WorkingDir,
+		Steps:      []util.JobStep{}, // the accounting index has no steps
+	}
+	if elasticJob.ArrayJobId != nil && elasticJob.ArrayTaskId != nil && *elasticJob.ArrayJobId != 0 {
+		ret.SlurmId = fmt.Sprintf("%v_%v", *elasticJob.ArrayJobId, *elasticJob.ArrayTaskId)
+	} else if elasticJob.HetJobId != nil && elasticJob.HetJobOffset != nil && *elasticJob.HetJobId != 0 {
+		ret.SlurmId = fmt.Sprintf("%v+%v", *elasticJob.HetJobId, *elasticJob.HetJobOffset)
+	}
+	exit_code, signal, _ := strings.Cut(elasticJob.ExitCode, ":")
+	ret.ExitCode, _ = strconv.Atoi(exit_code)
+	ret.Signal, _ = strconv.Atoi(signal)
+	if time_limit, err := strconv.Atoi(strings.Trim(string(elasticJob.TimeLimit), `"`)); err == nil {
+		ret.TimeLimit = time.Duration(time_limit) * time.Minute
+	}
+	return &ret, nil
 }
 
 type GpuTemperatureIndexed struct {
@@ -116,22 +276,32 @@ type GpuTemperatureIndexed struct {
 	Temperatures []float64
 }
 type GpuTemperatures struct {
+	Warnings
 	Time []time.Time
 	// key==node_id, value = 4 arrays of floats, for the 4 GPUs
 	Temperatures map[string][]GpuTemperatureIndexed
 }
 
-func (c *Client) GetGpuTemperature(nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*GpuTemperatures, error) {
+func (c *Client) GetGpuTemperature(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*GpuTemperatures, error) {
 	if logger == nil {
 		logger = logging.Get()
 	}
 
+	interval := get_interval(from, to, time.Minute, opts)
+	return query_chunked(ctx, c, nodes, from, to, interval, 5, func(ctx context.Context, nodes []util.Node) (*GpuTemperatures, error) {
+		return c.get_gpu_temperature(ctx, nodes, from, to, interval, opts, logger)
+	})
+}
+
+func (c *Client) get_gpu_temperature(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, interval time.Duration, opts util.QueryOptions, logger *zerolog.Logger) (*GpuTemperatures, error) {
 	nodesOfInterest := []string{}
 	for _, n := range nodes {
 		n1, _ := strings.CutPrefix(n.Nid, "nid")
 		nodesOfInterest = append(nodesOfInterest, strings.TrimLeft(n1, "0"))
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	res, err := c.Search().
 		Index(".ds-metrics-facility.telemetry-alps*").
 		Request(&search.Request{
@@ -160,13 +330,14 @@ func (c *Client) GetGpuTemperature(nodes []util.Node, from time.Time, to time.Ti
 			Aggregations: map[string]types.Aggregations{
 				"timestamps": {
 					DateHistogram: &types.DateHistogramAggregation{
-						Field:            ptr("@timestamp"),
-						CalendarInterval: &calendarinterval.Minute,
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
 					},
 					Aggregations: map[string]types.Aggregations{
 						"nodes": {
 							Terms: &types.TermsAggregation{
-								Size:  ptr(2048),
+								Size:  ptr(len(nodesOfInterest)),
 								Field: ptr("nid"),
 							},
 							Aggregations: map[string]types.Aggregations{
@@ -175,7 +346,7 @@ func (c *Client) GetGpuTemperature(nodes []util.Node, from time.Time, to time.Ti
 										Field: ptr("Sensor.Index"),
 									},
 									Aggregations: map[string]types.Aggregations{
-										"temperature": {Max: &types.MaxAggregation{Field: ptr("Sensor.Value")}},
+										"temperature": value_aggregation(cmp.Or(opts.Agg, "max"), "Sensor.Value"),
 									},
 								},
 							},
@@ -183,7 +354,7 @@ func (c *Client) GetGpuTemperature(nodes []util.Node, from time.Time, to time.Ti
 					},
 				},
 			},
-		}).Do(context.Background())
+		}).Do(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed GPU temperature searching in elastic: %w", err)
@@ -198,15 +369,17 @@ func (c *Client) GetGpuTemperature(nodes []util.Node, from time.Time, to time.Ti
 	ret := GpuTemperatures{Temperatures: map[string][]GpuTemperatureIndexed{}}
 	for _, timestampBucket := range timestampBuckets {
 		ret.Time = append(ret.Time, time.Unix(timestampBucket.Key/1000, 0))
+		ret.check_truncated(timestampBucket.Aggregations["nodes"], "nodes")
 		nodeBuckets := timestampBucket.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket)
 		// append to every already known node and every gpuIndex a NaN, such that it will have in the end the same length as the time array
 		for _, t := range ret.Temperatures {
 			for i := range t {
-				t[i].Temperatures = append(t[i].Temperatures, 0)
+				t[i].Temperatures = append(t[i].Temperatures, math.NaN())
 			}
 		}
 		for _, nodeBucket := range nodeBuckets {
 			node_id := "nid" + strings.Repeat("0", 6-len(nodeBucket.Key.(string))) + nodeBucket.Key.(string)
+			ret.check_truncated(nodeBucket.Aggregations["gpu_idx"], "GPUs")
 			gpuBuckets := nodeBucket.Aggregations["gpu_idx"].(*types.LongTermsAggregate).Buckets.([]types.LongTermsBucket)
 			for _, gpuBucket := range gpuBuckets {
 				gpuIdx := int(gpuBucket.Key)
@@ -214,13 +387,10 @@ func (c *Client) GetGpuTemperature(nodes []util.Node, from time.Time, to time.Ti
 				// ensure also that it is prefilled with as many NaN as the first  array
 				for len(ret.Temperatures[node_id]) <= gpuIdx {
 					ret.Temperatures[node_id] = append(ret.Temperatures[node_id], GpuTemperatureIndexed{})
-					ret.Temperatures[node_id][len(ret.Temperatures[node_id])-1].Temperatures = make([]float64, len(ret.Time))
-					for idx := range ret.Time {
-						ret.Temperatures[node_id][len(ret.Temperatures[node_id])-1].Temperatures[idx] = 0
-					}
+					ret.Temperatures[node_id][len(ret.Temperatures[node_id])-1].Temperatures = NewSeries(len(ret.Time))
 				}
 				ret.Temperatures[node_id][gpuIdx].GpuIndex = gpuIdx
-				ret.Temperatures[node_id][gpuIdx].Temperatures[len(ret.Time)-1] = f64(gpuBucket.Aggregations["temperature"].(*types.MaxAggregate).Value, 0)
+				ret.Temperatures[node_id][gpuIdx].Temperatures[len(ret.Time)-1] = value_or_missing(aggregate_value(gpuBucket.Aggregations["temperature"]))
 			}
 		}
 	}
@@ -237,10 +407,16 @@ type FilesystemStats struct {
 	Load        [][5]int64
 }
 
-func (c *Client) GetGlobalFilesystem(fs Filesystem, from time.Time, to time.Time, logger *zerolog.Logger) (*FilesystemStats, error) {
+func (c *Client) GetGlobalFilesystem(ctx context.Context, fs Filesystem, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*FilesystemStats, error) {
 	if logger == nil {
 		logger = logging.Get()
 	}
+
+	// the OSS/MDS report every minute, larger buckets are averaged to keep the unit per second
+	interval := get_interval(from, to, time.Minute, opts)
+	samples_per_bucket := interval.Seconds() / time.Minute.Seconds()
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	res, err := c.Search().
 		Index(".ds-metrics-legacy.telemetry-clusterstor*").
 		Request(&search.Request{
@@ -274,8 +450,9 @@ func (c *Client) GetGlobalFilesystem(fs Filesystem, from time.Time, to time.Time
 			Aggregations: map[string]types.Aggregations{
 				"timestamps": {
 					DateHistogram: &types.DateHistogramAggregation{
-						Field:            ptr("@timestamp"),
-						CalendarInterval: &calendarinterval.Minute,
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
 					},
 					Aggregations: map[string]types.Aggregations{
 						"metadataops": {Sum: &types.SumAggregation{Field: ptr("totops")}},
@@ -298,7 +475,7 @@ func (c *Client) GetGlobalFilesystem(fs Filesystem, from time.Time, to time.Time
 					},
 				},
 			},
-		}).Do(context.Background())
+		}).Do(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed filesystem stats searching in elastic: %w", err)
@@ -312,37 +489,194 @@ func (c *Client) GetGlobalFilesystem(fs Filesystem, from time.Time, to time.Time
 	var ret FilesystemStats
 	for _, bucket := range aggregateBuckets {
 		ret.Time = append(ret.Time, time.Unix(bucket.Key/1000, 0))
-		ret.MetadataOPS = append(ret.MetadataOPS, f64(bucket.Aggregations["metadataops"].(*types.SumAggregate).Value, math.NaN()))
-		ret.ReadBytes = append(ret.ReadBytes, f64(bucket.Aggregations["read_bytes"].(*types.SumAggregate).Value, math.NaN()))
-		ret.ReadIOPS = append(ret.ReadIOPS, f64(bucket.Aggregations["read_iops"].(*types.SumAggregate).Value, math.NaN()))
-		ret.WriteBytes = append(ret.WriteBytes, f64(bucket.Aggregations["write_bytes"].(*types.SumAggregate).Value, math.NaN()))
-		ret.WriteIOPS = append(ret.WriteIOPS, f64(bucket.Aggregations["write_iops"].(*types.SumAggregate).Value, math.NaN()))
+		if bucket.DocCount == 0 {
+			// the sums of an empty bucket are 0, but the filesystem did not report anything
+			ret.MetadataOPS = append(ret.MetadataOPS, math.NaN())
+			ret.ReadBytes = append(ret.ReadBytes, math.NaN())
+			ret.ReadIOPS = append(ret.ReadIOPS, math.NaN())
+			ret.WriteBytes = append(ret.WriteBytes, math.NaN())
+			ret.WriteIOPS = append(ret.WriteIOPS, math.NaN())
+			ret.Load = append(ret.Load, [5]int64{})
+			continue
+		}
+		ret.MetadataOPS = append(ret.MetadataOPS, f64(bucket.Aggregations["metadataops"].(*types.SumAggregate).Value, math.NaN())/samples_per_bucket)
+		ret.ReadBytes = append(ret.ReadBytes, f64(bucket.Aggregations["read_bytes"].(*types.SumAggregate).Value, math.NaN())/samples_per_bucket)
+		ret.ReadIOPS = append(ret.ReadIOPS, f64(bucket.Aggregations["read_iops"].(*types.SumAggregate).Value, math.NaN())/samples_per_bucket)
+		ret.WriteBytes = append(ret.WriteBytes, f64(bucket.Aggregations["write_bytes"].(*types.SumAggregate).Value, math.NaN())/samples_per_bucket)
+		ret.WriteIOPS = append(ret.WriteIOPS, f64(bucket.Aggregations["write_iops"].(*types.SumAggregate).Value, math.NaN())/samples_per_bucket)
 
 		LoadBuckets := bucket.Aggregations["load_one"].(*types.RangeAggregate).Buckets.([]types.RangeBucket)
-		ret.Load = append(ret.Load, [5]int64{LoadBuckets[0].DocCount, LoadBuckets[1].DocCount, LoadBuckets[2].DocCount, LoadBuckets[3].DocCount, LoadBuckets[4].DocCount})
+		servers := func(doc_count int64) int64 { return int64(math.Round(float64(doc_count) / samples_per_bucket)) }
+		ret.Load = append(ret.Load, [5]int64{servers(LoadBuckets[0].DocCount), servers(LoadBuckets[1].DocCount), servers(LoadBuckets[2].DocCount), servers(LoadBuckets[3].DocCount), servers(LoadBuckets[4].DocCount)})
+	}
+
+	return &ret, nil
+}
+
+type TargetStats struct {
+	ReadBytes   []float64
+	WriteBytes  []float64
+	ReadIOPS    []float64
+	WriteIOPS   []float64
+	MetadataOPS []float64
+}
+type JobFilesystemStats struct {
+	Warnings
+	Time          []time.Time
+	StatsByTarget map[string]*TargetStats // key==OST/MDT name, e.g. capstor-OST0001
+}
+
+// GetJobFilesystem returns the I/O of a single job from the Lustre jobstats, which are keyed by the Slurm job id
+func (c *Client) GetJobFilesystem(ctx context.Context, fs Filesystem, jobid string, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*JobFilesystemStats, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	interval := get_interval(from, to, 60*time.Second, opts)
+	query := &types.Query{
+		Bool: &types.BoolQuery{
+			Filter: []types.Query{
+				{
+					Term: map[string]types.TermQuery{"System": {Value: fs}},
+				}, {
+					Term: map[string]types.TermQuery{"job_id": {Value: jobid}},
+				}, {
+					Range: map[string]types.RangeQuery{
+						"@timestamp": types.DateRangeQuery{
+							Format: ptr("epoch_second"),
+							Gte:    ptr(strconv.FormatInt(from.Unix(), 10)),
+							Lt:     ptr(strconv.FormatInt(to.Unix(), 10)),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	// every time bucket has a bucket for every target that the job used, count them to stay below max_buckets
+	res, err := c.Search().
+		Index(".ds-metrics-legacy.telemetry-clusterstor.jobstats*").
+		Request(&search.Request{
+			Size:  ptr(0),
+			Query: query,
+			Aggregations: map[string]types.Aggregations{
+				"targets": {Cardinality: &types.CardinalityAggregation{Field: ptr("target")}},
+			},
+		}).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed counting job filesystem targets in elastic: %w", err)
+	}
+	targets := int(res.Aggregations["targets"].(*types.CardinalityAggregate).Value)
+	if num_time_buckets := count_time_buckets(from, to, interval); num_time_buckets*(1+targets) > c.maxBuckets {
+		return nil, too_many_time_buckets(num_time_buckets, c.maxBuckets/(1+targets))
+	}
+
+	res, err = c.Search().
+		Index(".ds-metrics-legacy.telemetry-clusterstor.jobstats*").
+		Request(&search.Request{
+			Size:  ptr(0), // we are only interested in the aggregation results
+			Query: query,
+			Aggregations: map[string]types.Aggregations{
+				"timestamps": {
+					DateHistogram: &types.DateHistogramAggregation{
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
+					},
+					Aggregations: map[string]types.Aggregations{
+						"targets": {
+							Terms: &types.TermsAggregation{
+								Size:  ptr(2048),
+								Field: ptr("target"),
+							},
+							Aggregations: map[string]types.Aggregations{
+								"metadataops": {Sum: &types.SumAggregation{Field: ptr("metadata_ops")}},
+								"read_bytes":  {Sum: &types.SumAggregation{Field: ptr("read_bytes")}},
+								"read_iops":   {Sum: &types.SumAggregation{Field: ptr("read_iops")}},
+								"write_bytes": {Sum: &types.SumAggregation{Field: ptr("write_bytes")}},
+								"write_iops":  {Sum: &types.SumAggregation{Field: ptr("write_iops")}},
+							},
+						},
+					},
+				},
+			},
+		}).Do(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed job filesystem stats searching in elastic: %w", err)
+	}
+
+	timestampBuckets := res.Aggregations["timestamps"].(*types.DateHistogramAggregate).Buckets.([]types.DateHistogramBucket)
+	logger.Debug().Msgf("Querying job filesystem stats from elastic took %vms. Num results in aggregation=%v", res.Took, len(timestampBuckets))
+	if len(timestampBuckets) > 0 {
+		logger.Debug().Msgf("First bucket result: %+v", timestampBuckets[0])
 	}
 
+	ret := JobFilesystemStats{StatsByTarget: map[string]*TargetStats{}}
+	for _, timestampBucket := range timestampBuckets {
+		ret.Time = append(ret.Time, time.Unix(timestampBucket.Key/1000, 0))
+		// append to every already known target a 0, the job did no I/O on targets without a bucket
+		for _, t := range ret.StatsByTarget {
+			t.ReadBytes = append(t.ReadBytes, 0)
+			t.WriteBytes = append(t.WriteBytes, 0)
+			t.ReadIOPS = append(t.ReadIOPS, 0)
+			t.WriteIOPS = append(t.WriteIOPS, 0)
+			t.MetadataOPS = append(t.MetadataOPS, 0)
+		}
+		ret.check_truncated(timestampBucket.Aggregations["targets"], "filesystem targets")
+		targetBuckets := timestampBucket.Aggregations["targets"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket)
+		for _, targetBucket := range targetBuckets {
+			target := targetBucket.Key.(string)
+			t, exists := ret.StatsByTarget[target]
+			if !exists {
+				t = &TargetStats{
+					ReadBytes:   make([]float64, len(ret.Time)),
+					WriteBytes:  make([]float64, len(ret.Time)),
+					ReadIOPS:    make([]float64, len(ret.Time)),
+					WriteIOPS:   make([]float64, len(ret.Time)),
+					MetadataOPS: make([]float64, len(ret.Time)),
+				}
+				ret.StatsByTarget[target] = t
+			}
+			last := len(ret.Time) - 1
+			t.MetadataOPS[last] = f64(targetBucket.Aggregations["metadataops"].(*types.SumAggregate).Value, 0)
+			t.ReadBytes[last] = f64(targetBucket.Aggregations["read_bytes"].(*types.SumAggregate).Value, 0)
+			t.ReadIOPS[last] = f64(targetBucket.Aggregations["read_iops"].(*types.SumAggregate).Value, 0)
+			t.WriteBytes[last] = f64(targetBucket.Aggregations["write_bytes"].(*types.SumAggregate).Value, 0)
+			t.WriteIOPS[last] = f64(targetBucket.Aggregations["write_iops"].(*types.SumAggregate).Value, 0)
+		}
+	}
 	return &ret, nil
 }
 
 type ChassisEnergy struct {
+	Warnings
 	Time         []time.Time
 	EnergyByNode map[string][]float64 // key==node-id
 }
 
-func (c *Client) GetChassisEnergy(nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*ChassisEnergy, error) {
+func (c *Client) GetChassisEnergy(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*ChassisEnergy, error) {
 	if logger == nil {
 		logger = logging.Get()
 	}
 
-	interval := get_interval(from, to, 5*time.Second)
+	interval := get_interval(from, to, 5*time.Second, opts)
+	return query_chunked(ctx, c, nodes, from, to, interval, 1, func(ctx context.Context, nodes []util.Node) (*ChassisEnergy, error) {
+		return c.get_chassis_energy(ctx, nodes, from, to, interval, opts, logger)
+	})
+}
 
+func (c *Client) get_chassis_energy(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, interval time.Duration, opts util.QueryOptions, logger *zerolog.Logger) (*ChassisEnergy, error) {
 	nodesOfInterest := []string{}
 	for _, n := range nodes {
 		n1, _ := strings.CutPrefix(n.Nid, "nid")
 		nodesOfInterest = append(nodesOfInterest, strings.TrimLeft(n1, "0"))
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	res, err := c.Search().
 		Index(".ds-metrics-facility.telemetry-alps.energy*").
 		Request(&search.Request{
@@ -375,13 +709,14 @@ func (c *Client) GetChassisEnergy(nodes []util.Node, from time.Time, to time.Tim
 			Aggregations: map[string]types.Aggregations{
 				"timestamps": {
 					DateHistogram: &types.DateHistogramAggregation{
-						Field:         ptr("@timestamp"),
-						FixedInterval: ptr(interval),
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
 					},
 					Aggregations: map[string]types.Aggregations{
 						"nodes": {
 							Terms: &types.TermsAggregation{
-								Size:  ptr(2048),
+								Size:  ptr(len(nodesOfInterest)),
 								Field: ptr("nid"),
 							},
 							Aggregations: map[string]types.Aggregations{
@@ -391,7 +726,7 @@ func (c *Client) GetChassisEnergy(nodes []util.Node, from time.Time, to time.Tim
 					},
 				},
 			},
-		}).Do(context.Background())
+		}).Do(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed getting node's energy searching in elastic: %w", err)
@@ -411,6 +746,7 @@ func (c *Client) GetChassisEnergy(nodes []util.Node, from time.Time, to time.Tim
 			nodesThisBucket[n.Nid] = true
 		}
 		ret.Time = append(ret.Time, time.Unix(timestampBucket.Key/1000, 0))
+		ret.check_truncated(timestampBucket.Aggregations["nodes"], "nodes")
 		nodeBuckets := timestampBucket.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket)
 		for _, nodeBucket := range nodeBuckets {
 			node_id := "nid" + strings.Repeat("0", 6-len(nodeBucket.Key.(string))) + nodeBucket.Key.(string)
@@ -424,34 +760,38 @@ func (c *Client) GetChassisEnergy(nodes []util.Node, from time.Time, to time.Tim
 			}
 		}
 		for nid, _ := range nodesThisBucket {
-			if ret.EnergyByNode[nid] != nil {
-				ret.EnergyByNode[nid] = append(ret.EnergyByNode[nid], ret.EnergyByNode[nid][len(ret.EnergyByNode[nid])-1])
-			} else {
-				ret.EnergyByNode[nid] = append(ret.EnergyByNode[nid], -1.0e6)
-			}
+			ret.EnergyByNode[nid] = append(ret.EnergyByNode[nid], math.NaN())
 		}
 	}
 	return &ret, nil
 }
 
 type ChassisPower struct {
+	Warnings
 	Time        []time.Time
 	PowerByNode map[string][]float64 // key==node-id
 }
 
-func (c *Client) GetChassisPower(nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*ChassisPower, error) {
+func (c *Client) GetChassisPower(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*ChassisPower, error) {
 	if logger == nil {
 		logger = logging.Get()
 	}
 
-	interval := get_interval(from, to, 10*time.Second)
+	interval := get_interval(from, to, 10*time.Second, opts)
+	return query_chunked(ctx, c, nodes, from, to, interval, 1, func(ctx context.Context, nodes []util.Node) (*ChassisPower, error) {
+		return c.get_chassis_power(ctx, nodes, from, to, interval, opts, logger)
+	})
+}
 
+func (c *Client) get_chassis_power(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, interval time.Duration, opts util.QueryOptions, logger *zerolog.Logger) (*ChassisPower, error) {
 	nodesOfInterest := []string{}
 	for _, n := range nodes {
 		n1, _ := strings.CutPrefix(n.Nid, "nid")
 		nodesOfInterest = append(nodesOfInterest, strings.TrimLeft(n1, "0"))
 	}
 	// MessageId :"CrayTelemetry.Power" and data_stream.namespace:alps.power and Sensor.PhysicalContext:VoltageRegulator and Sensor.Index:0 and Sensor.PhysicalSubContext:Input and Sensor.Location:x1201c3s3b0n0
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	res, err := c.Search().
 		Index(".ds-metrics-facility.telemetry-alps.power*").
 		Request(&search.Request{
@@ -484,23 +824,24 @@ func (c *Client) GetChassisPower(nodes []util.Node, from time.Time, to time.Time
 			Aggregations: map[string]types.Aggregations{
 				"timestamps": {
 					DateHistogram: &types.DateHistogramAggregation{
-						Field:         ptr("@timestamp"),
-						FixedInterval: ptr(interval),
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
 					},
 					Aggregations: map[string]types.Aggregations{
 						"nodes": {
 							Terms: &types.TermsAggregation{
-								Size:  ptr(2048),
+								Size:  ptr(len(nodesOfInterest)),
 								Field: ptr("nid"),
 							},
 							Aggregations: map[string]types.Aggregations{
-								"power": {Avg: &types.AverageAggregation{Field: ptr("Sensor.Value")}},
+								"power": value_aggregation(cmp.Or(opts.Agg, "avg"), "Sensor.Value"),
 							},
 						},
 					},
 				},
 			},
-		}).Do(context.Background())
+		}).Do(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed getting node's energy searching in elastic: %w", err)
@@ -519,15 +860,16 @@ func (c *Client) GetChassisPower(nodes []util.Node, from time.Time, to time.Time
 			nodesThisBucket[n.Nid] = true
 		}
 		ret.Time = append(ret.Time, time.Unix(timestampBucket.Key/1000, 0))
+		ret.check_truncated(timestampBucket.Aggregations["nodes"], "nodes")
 		nodeBuckets := timestampBucket.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket)
 		for _, nodeBucket := range nodeBuckets {
 			node_id := "nid" + strings.Repeat("0", 6-len(nodeBucket.Key.(string))) + nodeBucket.Key.(string)
 			delete(nodesThisBucket, node_id)
-			ret.PowerByNode[node_id] = append(ret.PowerByNode[node_id], f64(nodeBucket.Aggregations["power"].(*types.AvgAggregate).Value, 0))
+			ret.PowerByNode[node_id] = append(ret.PowerByNode[node_id], value_or_missing(aggregate_value(nodeBucket.Aggregations["power"])))
 		}
-		// fill missing node values with a 0
+		// nodes without a value in this bucket
 		for nid, _ := range nodesThisBucket {
-			ret.PowerByNode[nid] = append(ret.PowerByNode[nid], 0)
+			ret.PowerByNode[nid] = append(ret.PowerByNode[nid], math.NaN())
 		}
 	}
 	return &ret, nil
@@ -538,22 +880,30 @@ type DcgmDataIndexed struct {
 	Data     []float64
 }
 type DcgmMetric struct {
+	Warnings
 	Time         []time.Time
 	MetricByNode map[string][]DcgmDataIndexed
 }
 
-func (c *Client) GetDcgmData(nodes []util.Node, from time.Time, to time.Time, metric string, logger *zerolog.Logger) (*DcgmMetric, error) {
+func (c *Client) GetDcgmData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, metric string, opts util.QueryOptions, logger *zerolog.Logger) (*DcgmMetric, error) {
 	if logger == nil {
 		logger = logging.Get()
 	}
 
-	interval := get_interval(from, to, 10*time.Second)
+	interval := get_interval(from, to, 10*time.Second, opts)
+	return query_chunked(ctx, c, nodes, from, to, interval, 5, func(ctx context.Context, nodes []util.Node) (*DcgmMetric, error) {
+		return c.get_dcgm_data(ctx, nodes, from, to, interval, metric, opts, logger)
+	})
+}
 
+func (c *Client) get_dcgm_data(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, interval time.Duration, metric string, opts util.QueryOptions, logger *zerolog.Logger) (*DcgmMetric, error) {
 	nodesOfInterest := []string{}
 	for _, n := range nodes {
 		nodesOfInterest = append(nodesOfInterest, n.Nid)
 	}
 	// metric.name:cray_storage.dcgm.<metric> and data_stream.namespace:alps.node and metric.dimensions.hostname:nid001234
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	res, err := c.Search().
 		Index(".ds-metrics-facility.telemetry-alps.node*").
 		Request(&search.Request{
@@ -582,13 +932,14 @@ func (c *Client) GetDcgmData(nodes []util.Node, from time.Time, to time.Time, me
 			Aggregations: map[string]types.Aggregations{
 				"timestamps": {
 					DateHistogram: &types.DateHistogramAggregation{
-						Field:         ptr("@timestamp"),
-						FixedInterval: ptr(interval),
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
 					},
 					Aggregations: map[string]types.Aggregations{
 						"nodes": {
 							Terms: &types.TermsAggregation{
-								Size:  ptr(2048),
+								Size:  ptr(len(nodesOfInterest)),
 								Field: ptr("metric.dimensions.hostname"),
 							},
 							Aggregations: map[string]types.Aggregations{
@@ -597,7 +948,7 @@ func (c *Client) GetDcgmData(nodes []util.Node, from time.Time, to time.Time, me
 										Field: ptr("metric.dimensions.gpu_id"),
 									},
 									Aggregations: map[string]types.Aggregations{
-										"metric.value": {Avg: &types.AverageAggregation{Field: ptr("metric.value")}},
+										"metric.value": value_aggregation(cmp.Or(opts.Agg, "avg"), "metric.value"),
 									},
 								},
 							},
@@ -605,7 +956,7 @@ func (c *Client) GetDcgmData(nodes []util.Node, from time.Time, to time.Time, me
 					},
 				},
 			},
-		}).Do(context.Background())
+		}).Do(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed getting node's DCGM metric %v, when searching in elastic: %w", metric, err)
@@ -620,15 +971,17 @@ func (c *Client) GetDcgmData(nodes []util.Node, from time.Time, to time.Time, me
 	ret := DcgmMetric{MetricByNode: map[string][]DcgmDataIndexed{}}
 	for _, timestampBucket := range timestampBuckets {
 		ret.Time = append(ret.Time, time.Unix(timestampBucket.Key/1000, 0))
+		ret.check_truncated(timestampBucket.Aggregations["nodes"], "nodes")
 		nodeBuckets := timestampBucket.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket)
-		// append to every already known node and every gpuIndex a 0, such that it will have in the end the same length as the time array
+		// append to every already known node and every gpuIndex a NaN, such that it will have in the end the same length as the time array
 		for _, t := range ret.MetricByNode {
 			for i := range t {
-				t[i].Data = append(t[i].Data, 0)
+				t[i].Data = append(t[i].Data, math.NaN())
 			}
 		}
 		for _, nodeBucket := range nodeBuckets {
 			node_id := nodeBucket.Key.(string)
+			ret.check_truncated(nodeBucket.Aggregations["gpu_idx"], "GPUs")
 			gpuBuckets := nodeBucket.Aggregations["gpu_idx"].(*types.LongTermsAggregate).Buckets.([]types.LongTermsBucket)
 			for _, gpuBucket := range gpuBuckets {
 				gpuIdx := int(gpuBucket.Key)
@@ -636,41 +989,108 @@ func (c *Client) GetDcgmData(nodes []util.Node, from time.Time, to time.Time, me
 				// ensure also that it is prefilled with as many NaN as the first  array
 				for len(ret.MetricByNode[node_id]) <= gpuIdx {
 					ret.MetricByNode[node_id] = append(ret.MetricByNode[node_id], DcgmDataIndexed{})
-					ret.MetricByNode[node_id][len(ret.MetricByNode[node_id])-1].Data = make([]float64, len(ret.Time))
-					for idx := range ret.Time {
-						ret.MetricByNode[node_id][len(ret.MetricByNode[node_id])-1].Data[idx] = 0
-					}
+					ret.MetricByNode[node_id][len(ret.MetricByNode[node_id])-1].Data = NewSeries(len(ret.Time))
 				}
 				ret.MetricByNode[node_id][gpuIdx].GpuIndex = gpuIdx
-				ret.MetricByNode[node_id][gpuIdx].Data[len(ret.Time)-1] = f64(gpuBucket.Aggregations["metric.value"].(*types.MaxAggregate).Value, 0)
+				ret.MetricByNode[node_id][gpuIdx].Data[len(ret.Time)-1] = value_or_missing(aggregate_value(gpuBucket.Aggregations["metric.value"]))
 			}
 		}
 	}
 	return &ret, nil
 }
 
+// GetDcgmMetricNames returns the DCGM metrics (without the prefix `cray_storage.dcgm.`) that have data for any of the nodes in the time window
+func (c *Client) GetDcgmMetricNames(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) ([]string, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	nodesOfInterest := []string{}
+	for _, n := range nodes {
+		nodesOfInterest = append(nodesOfInterest, n.Nid)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	res, err := c.Search().
+		Index(".ds-metrics-facility.telemetry-alps.node*").
+		Request(&search.Request{
+			Size: ptr(0), // we are only interested in the aggregation results
+			Query: &types.Query{
+				Bool: &types.BoolQuery{
+					Filter: []types.Query{
+						{
+							Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"metric.dimensions.hostname": nodesOfInterest}},
+						}, {
+							Term: map[string]types.TermQuery{"data_stream.namespace": {Value: "alps.node"}},
+						}, {
+							Prefix: map[string]types.PrefixQuery{"metric.name": {Value: "cray_storage.dcgm."}},
+						}, {
+							Range: map[string]types.RangeQuery{
+								"@timestamp": types.DateRangeQuery{
+									Format: ptr("epoch_second"),
+									Gte:    ptr(strconv.FormatInt(from.Unix(), 10)),
+									Lt:     ptr(strconv.FormatInt(to.Unix(), 10)),
+								},
+							},
+						},
+					},
+				},
+			},
+			Aggregations: map[string]types.Aggregations{
+				"metrics": {
+					Terms: &types.TermsAggregation{
+						Size:  ptr(1000),
+						Field: ptr("metric.name"),
+					},
+				},
+			},
+		}).Do(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting available DCGM metrics, when searching in elastic: %w", err)
+	}
+
+	metricBuckets := res.Aggregations["metrics"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket)
+	logger.Debug().Msgf("Querying available DCGM metrics from elastic took %vms. Num results in aggregation=%v", res.Took, len(metricBuckets))
+
+	ret := []string{}
+	for _, metricBucket := range metricBuckets {
+		metric, _ := strings.CutPrefix(metricBucket.Key.(string), "cray_storage.dcgm.")
+		ret = append(ret, metric)
+	}
+	return ret, nil
+}
+
 type Memory struct {
 	Free   []float64
 	Cache  []float64
 	Buffer []float64
 }
 type MemoryData struct {
+	Warnings
 	Time         []time.Time
 	MemoryByNode map[string]*Memory // key==node-id
 }
 
-func (c *Client) GetMemoryData(nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*MemoryData, error) {
+func (c *Client) GetMemoryData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*MemoryData, error) {
 	if logger == nil {
 		logger = logging.Get()
 	}
 
-	interval := get_interval(from, to, 30*time.Second)
+	interval := get_interval(from, to, 30*time.Second, opts)
+	return query_chunked(ctx, c, nodes, from, to, interval, 4, func(ctx context.Context, nodes []util.Node) (*MemoryData, error) {
+		return c.get_memory_data(ctx, nodes, from, to, interval, opts, logger)
+	})
+}
 
+func (c *Client) get_memory_data(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, interval time.Duration, opts util.QueryOptions, logger *zerolog.Logger) (*MemoryData, error) {
 	nodesOfInterest := []string{}
 	for _, n := range nodes {
 		nodesOfInterest = append(nodesOfInterest, n.Nid)
 	}
 	// MessageId :"CrayTelemetry.Power" and data_stream.namespace:alps.power and Sensor.PhysicalContext:VoltageRegulator and Sensor.Index:0 and Sensor.PhysicalSubContext:Input and Sensor.Location:x1201c3s3b0n0
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	res, err := c.Search().
 		Index(".ds-metrics-facility.telemetry-alps.node*").
 		Request(&search.Request{
@@ -699,32 +1119,33 @@ func (c *Client) GetMemoryData(nodes []util.Node, from time.Time, to time.Time,
 			Aggregations: map[string]types.Aggregations{
 				"timestamps": {
 					DateHistogram: &types.DateHistogramAggregation{
-						Field:         ptr("@timestamp"),
-						FixedInterval: ptr(interval),
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
 					},
 					Aggregations: map[string]types.Aggregations{
 						"nodes": {
 							Terms: &types.TermsAggregation{
-								Size:  ptr(2048),
+								Size:  ptr(len(nodesOfInterest)),
 								Field: ptr("metric.dimensions.hostname"),
 							},
 							Aggregations: map[string]types.Aggregations{
 								"free": {
 									Filter: &types.Query{Term: map[string]types.TermQuery{"metric.name": {Value: "cray_storage.cray_vmstat.mem_free"}}},
 									Aggregations: map[string]types.Aggregations{
-										"value": {Min: &types.MinAggregation{Field: ptr("metric.value")}},
+										"value": value_aggregation(cmp.Or(opts.Agg, "min"), "metric.value"),
 									},
 								},
 								"cache": {
 									Filter: &types.Query{Term: map[string]types.TermQuery{"metric.name": {Value: "cray_storage.cray_vmstat.mem_cache"}}},
 									Aggregations: map[string]types.Aggregations{
-										"value": {Max: &types.MaxAggregation{Field: ptr("metric.value")}},
+										"value": value_aggregation(cmp.Or(opts.Agg, "max"), "metric.value"),
 									},
 								},
 								"buffer": {
 									Filter: &types.Query{Term: map[string]types.TermQuery{"metric.name": {Value: "cray_storage.cray_vmstat.mem_buff"}}},
 									Aggregations: map[string]types.Aggregations{
-										"value": {Max: &types.MaxAggregation{Field: ptr("metric.value")}},
+										"value": value_aggregation(cmp.Or(opts.Agg, "max"), "metric.value"),
 									},
 								},
 							},
@@ -732,7 +1153,7 @@ func (c *Client) GetMemoryData(nodes []util.Node, from time.Time, to time.Time,
 					},
 				},
 			},
-		}).Do(context.Background())
+		}).Do(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed getting node's memory searching in elastic: %w", err)
@@ -747,17 +1168,25 @@ func (c *Client) GetMemoryData(nodes []util.Node, from time.Time, to time.Time,
 	ret := MemoryData{MemoryByNode: map[string]*Memory{}}
 	for _, timestampBucket := range timestampBuckets {
 		ret.Time = append(ret.Time, time.Unix(timestampBucket.Key/1000, 0))
+		ret.check_truncated(timestampBucket.Aggregations["nodes"], "nodes")
 		nodeBuckets := timestampBucket.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket)
+		// append to every already known node a NaN, such that it will have in the end the same length as the time array
+		for _, m := range ret.MemoryByNode {
+			m.Free = append(m.Free, math.NaN())
+			m.Cache = append(m.Cache, math.NaN())
+			m.Buffer = append(m.Buffer, math.NaN())
+		}
+		last := len(ret.Time) - 1
 		for _, nodeBucket := range nodeBuckets {
 			node_id := nodeBucket.Key.(string)
 			thisMemData, exists := ret.MemoryByNode[node_id]
 			if !exists {
-				ret.MemoryByNode[node_id] = &Memory{}
+				ret.MemoryByNode[node_id] = &Memory{Free: NewSeries(len(ret.Time)), Cache: NewSeries(len(ret.Time)), Buffer: NewSeries(len(ret.Time))}
 				thisMemData = ret.MemoryByNode[node_id]
 			}
-			thisMemData.Free = append(thisMemData.Free, f64(nodeBucket.Aggregations["free"].(*types.FilterAggregate).Aggregations["value"].(*types.MinAggregate).Value, 0))
-			thisMemData.Cache = append(thisMemData.Cache, f64(nodeBucket.Aggregations["cache"].(*types.FilterAggregate).Aggregations["value"].(*types.MaxAggregate).Value, 0))
-			thisMemData.Buffer = append(thisMemData.Buffer, f64(nodeBucket.Aggregations["buffer"].(*types.FilterAggregate).Aggregations["value"].(*types.MaxAggregate).Value, 0))
+			thisMemData.Free[last] = value_or_missing(aggregate_value(nodeBucket.Aggregations["free"].(*types.FilterAggregate).Aggregations["value"]))
+			thisMemData.Cache[last] = value_or_missing(aggregate_value(nodeBucket.Aggregations["cache"].(*types.FilterAggregate).Aggregations["value"]))
+			thisMemData.Buffer[last] = value_or_missing(aggregate_value(nodeBucket.Aggregations["buffer"].(*types.FilterAggregate).Aggregations["value"]))
 		}
 	}
 	return &ret, nil
@@ -768,22 +1197,30 @@ type Cpu struct {
 	System []float64
 }
 type CpuData struct {
+	Warnings
 	Time      []time.Time
 	CpuByNode map[string]*Cpu // key==node-id
 }
 
-func (c *Client) GetCpuData(nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*CpuData, error) {
+func (c *Client) GetCpuData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*CpuData, error) {
 	if logger == nil {
 		logger = logging.Get()
 	}
 
-	interval := get_interval(from, to, 30*time.Second)
+	interval := get_interval(from, to, 30*time.Second, opts)
+	return query_chunked(ctx, c, nodes, from, to, interval, 3, func(ctx context.Context, nodes []util.Node) (*CpuData, error) {
+		return c.get_cpu_data(ctx, nodes, from, to, interval, opts, logger)
+	})
+}
 
+func (c *Client) get_cpu_data(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, interval time.Duration, opts util.QueryOptions, logger *zerolog.Logger) (*CpuData, error) {
 	nodesOfInterest := []string{}
 	for _, n := range nodes {
 		nodesOfInterest = append(nodesOfInterest, n.Nid)
 	}
 	// MessageId :"CrayTelemetry.Power" and data_stream.namespace:alps.power and Sensor.PhysicalContext:VoltageRegulator and Sensor.Index:0 and Sensor.PhysicalSubContext:Input and Sensor.Location:x1201c3s3b0n0
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 	res, err := c.Search().
 		Index(".ds-metrics-facility.telemetry-alps.node*").
 		Request(&search.Request{
@@ -812,26 +1249,27 @@ func (c *Client) GetCpuData(nodes []util.Node, from time.Time, to time.Time, log
 			Aggregations: map[string]types.Aggregations{
 				"timestamps": {
 					DateHistogram: &types.DateHistogramAggregation{
-						Field:         ptr("@timestamp"),
-						FixedInterval: ptr(interval),
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
 					},
 					Aggregations: map[string]types.Aggregations{
 						"nodes": {
 							Terms: &types.TermsAggregation{
-								Size:  ptr(2048),
+								Size:  ptr(len(nodesOfInterest)),
 								Field: ptr("metric.dimensions.hostname"),
 							},
 							Aggregations: map[string]types.Aggregations{
 								"user": {
 									Filter: &types.Query{Term: map[string]types.TermQuery{"metric.name": {Value: "cray_storage.cray_vmstat.cpu_us"}}},
 									Aggregations: map[string]types.Aggregations{
-										"value": {Max: &types.MaxAggregation{Field: ptr("metric.value")}},
+										"value": value_aggregation(cmp.Or(opts.Agg, "max"), "metric.value"),
 									},
 								},
 								"system": {
 									Filter: &types.Query{Term: map[string]types.TermQuery{"metric.name": {Value: "cray_storage.cray_vmstat.cpu_sy"}}},
 									Aggregations: map[string]types.Aggregations{
-										"value": {Max: &types.MaxAggregation{Field: ptr("metric.value")}},
+										"value": value_aggregation(cmp.Or(opts.Agg, "max"), "metric.value"),
 									},
 								},
 							},
@@ -839,7 +1277,7 @@ func (c *Client) GetCpuData(nodes []util.Node, from time.Time, to time.Time, log
 					},
 				},
 			},
-		}).Do(context.Background())
+		}).Do(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed getting node's cpu searching in elastic: %w", err)
@@ -854,23 +1292,184 @@ func (c *Client) GetCpuData(nodes []util.Node, from time.Time, to time.Time, log
 	ret := CpuData{CpuByNode: map[string]*Cpu{}}
 	for _, timestampBucket := range timestampBuckets {
 		ret.Time = append(ret.Time, time.Unix(timestampBucket.Key/1000, 0))
+		ret.check_truncated(timestampBucket.Aggregations["nodes"], "nodes")
 		nodeBuckets := timestampBucket.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket)
+		// append to every already known node a NaN, such that it will have in the end the same length as the time array
+		for _, cpu := range ret.CpuByNode {
+			cpu.User = append(cpu.User, math.NaN())
+			cpu.System = append(cpu.System, math.NaN())
+		}
+		last := len(ret.Time) - 1
 		for _, nodeBucket := range nodeBuckets {
 			node_id := nodeBucket.Key.(string)
 			thisCpuData, exists := ret.CpuByNode[node_id]
 			if !exists {
-				ret.CpuByNode[node_id] = &Cpu{}
+				ret.CpuByNode[node_id] = &Cpu{User: NewSeries(len(ret.Time)), System: NewSeries(len(ret.Time))}
 				thisCpuData = ret.CpuByNode[node_id]
 			}
 			if nodeBucket.Aggregations["user"].(*types.FilterAggregate).Aggregations["value"] != nil {
-				thisCpuData.User = append(thisCpuData.User, f64(nodeBucket.Aggregations["user"].(*types.FilterAggregate).Aggregations["value"].(*types.MaxAggregate).Value, 0))
-			} else {
-				thisCpuData.User = append(thisCpuData.User, 0)
+				thisCpuData.User[last] = value_or_missing(aggregate_value(nodeBucket.Aggregations["user"].(*types.FilterAggregate).Aggregations["value"]))
 			}
 			if nodeBucket.Aggregations["system"].(*types.FilterAggregate).Aggregations["value"] != nil {
-				thisCpuData.System = append(thisCpuData.System, f64(nodeBucket.Aggregations["system"].(*types.FilterAggregate).Aggregations["value"].(*types.MaxAggregate).Value, 0))
-			} else {
-				thisCpuData.System = append(thisCpuData.System, 0)
+				thisCpuData.System[last] = value_or_missing(aggregate_value(nodeBucket.Aggregations["system"].(*types.FilterAggregate).Aggregations["value"]))
+			}
+		}
+	}
+	return &ret, nil
+}
+
+// the high-speed network counters, key==name in the API, value==metric name in elastic
+// all counters are monotonic per NIC and are returned as rate per second, summed over all NICs of a node
+var networkCounters = map[string]string{
+	"tx_bytes":    "cray_storage.cxi.tx_bytes",
+	"rx_bytes":    "cray_storage.cxi.rx_bytes",
+	"tx_packets":  "cray_storage.cxi.tx_packets",
+	"rx_packets":  "cray_storage.cxi.rx_packets",
+	"stalls":      "cray_storage.cxi.tx_stalls",
+	"link_errors": "cray_storage.cxi.link_errors",
+}
+
+type NetworkData struct {
+	Warnings
+	Time []time.Time
+	// key==node-id, value==map with key==counter name (see networkCounters) and the rate per second
+	CountersByNode map[string]map[string][]float64
+}
+
+func (c *Client) GetNetworkData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*NetworkData, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	interval := get_interval(from, to, 30*time.Second, opts)
+	return query_chunked(ctx, c, nodes, from, to, interval, 29, func(ctx context.Context, nodes []util.Node) (*NetworkData, error) {
+		return c.get_network_data(ctx, nodes, from, to, interval, opts, logger)
+	})
+}
+
+func (c *Client) get_network_data(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, interval time.Duration, opts util.QueryOptions, logger *zerolog.Logger) (*NetworkData, error) {
+	nodesOfInterest := []string{}
+	for _, n := range nodes {
+		nodesOfInterest = append(nodesOfInterest, n.Nid)
+	}
+	counterAggregations := map[string]types.Aggregations{}
+	for counter, metric_name := range networkCounters {
+		counterAggregations[counter] = types.Aggregations{
+			Filter: &types.Query{Term: map[string]types.TermQuery{"metric.name": {Value: metric_name}}},
+			Aggregations: map[string]types.Aggregations{
+				"value": {Max: &types.MaxAggregation{Field: ptr("metric.value")}},
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	res, err := c.Search().
+		Index(".ds-metrics-facility.telemetry-alps.node*").
+		Request(&search.Request{
+			Size: ptr(0), // we are only interested in the aggregation results
+			Query: &types.Query{
+				Bool: &types.BoolQuery{
+					Filter: []types.Query{
+						{
+							Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"metric.dimensions.hostname": nodesOfInterest}},
+						}, {
+							Term: map[string]types.TermQuery{"data_stream.namespace": {Value: "alps.node"}},
+						}, {
+							Prefix: map[string]types.PrefixQuery{"metric.name": {Value: "cray_storage.cxi."}},
+						}, {
+							Range: map[string]types.RangeQuery{
+								"@timestamp": types.DateRangeQuery{
+									Format: ptr("epoch_second"),
+									Gte:    ptr(strconv.FormatInt(from.Unix(), 10)),
+									Lt:     ptr(strconv.FormatInt(to.Unix(), 10)),
+								},
+							},
+						},
+					},
+				},
+			},
+			Aggregations: map[string]types.Aggregations{
+				"timestamps": {
+					DateHistogram: &types.DateHistogramAggregation{
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
+					},
+					Aggregations: map[string]types.Aggregations{
+						"nodes": {
+							Terms: &types.TermsAggregation{
+								Size:  ptr(len(nodesOfInterest)),
+								Field: ptr("metric.dimensions.hostname"),
+							},
+							Aggregations: map[string]types.Aggregations{
+								"nics": {
+									Terms: &types.TermsAggregation{
+										Field: ptr("metric.dimensions.nic"),
+									},
+									Aggregations: counterAggregations,
+								},
+							},
+						},
+					},
+				},
+			},
+		}).Do(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting node's network counters searching in elastic: %w", err)
+	}
+
+	timestampBuckets := res.Aggregations["timestamps"].(*types.DateHistogramAggregate).Buckets.([]types.DateHistogramBucket)
+	logger.Debug().Msgf("Querying node network from elastic took %vms. Num results in aggregation=%v", res.Took, len(timestampBuckets))
+	if len(timestampBuckets) > 0 {
+		logger.Debug().Msgf("First bucket result: %+v", timestampBuckets[0])
+	}
+
+	ret := NetworkData{CountersByNode: map[string]map[string][]float64{}}
+	// last seen counter value and time, key==node-id/nic/counter
+	type lastValue struct {
+		value float64
+		time  time.Time
+	}
+	lastValues := map[string]lastValue{}
+	for _, timestampBucket := range timestampBuckets {
+		bucketTime := time.Unix(timestampBucket.Key/1000, 0)
+		ret.Time = append(ret.Time, bucketTime)
+		// append to every already known node and counter a NaN, such that it will have in the end the same length as the time array
+		for _, counters := range ret.CountersByNode {
+			for counter := range counters {
+				counters[counter] = append(counters[counter], math.NaN())
+			}
+		}
+		ret.check_truncated(timestampBucket.Aggregations["nodes"], "nodes")
+		nodeBuckets := timestampBucket.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket)
+		for _, nodeBucket := range nodeBuckets {
+			node_id := nodeBucket.Key.(string)
+			if _, exists := ret.CountersByNode[node_id]; !exists {
+				ret.CountersByNode[node_id] = map[string][]float64{}
+				for counter := range networkCounters {
+					ret.CountersByNode[node_id][counter] = NewSeries(len(ret.Time))
+				}
+			}
+			ret.check_truncated(nodeBucket.Aggregations["nics"], "NICs")
+			for _, nicBucket := range terms_buckets(nodeBucket.Aggregations["nics"]) {
+				for counter := range networkCounters {
+					value := nicBucket.aggregations[counter].(*types.FilterAggregate).Aggregations["value"]
+					if value == nil || value.(*types.MaxAggregate).Value == nil {
+						continue
+					}
+					current := lastValue{float64(*value.(*types.MaxAggregate).Value), bucketTime}
+					key := fmt.Sprintf("%v/%v/%v", node_id, nicBucket.key, counter)
+					// the first value and counter resets do not give a rate
+					if last, ok := lastValues[key]; ok && current.value >= last.value && current.time.After(last.time) {
+						rate := &ret.CountersByNode[node_id][counter][len(ret.Time)-1]
+						if math.IsNaN(*rate) {
+							*rate = 0
+						}
+						*rate += (current.value - last.value) / current.time.Sub(last.time).Seconds()
+					}
+					lastValues[key] = current
+				}
 			}
 		}
 	}
@@ -878,15 +1477,29 @@ func (c *Client) GetCpuData(nodes []util.Node, from time.Time, to time.Time, log
 }
 
 // helper functions
-// get an automatic interval
-func get_interval(from, to time.Time, min_interval time.Duration) string {
+func max_buckets(config util.ElasticConfig) int {
+	if config.MaxBuckets > 0 {
+		return config.MaxBuckets
+	}
+	return default_max_buckets
+}
+
+// get the interval of the date histogram, either the client's selection (step or number of points) or an automatic
+// interval, but never smaller than the metric's min_interval
+func get_interval(from, to time.Time, min_interval time.Duration, opts util.QueryOptions) time.Duration {
 	total_sec := to.Sub(from).Seconds()
-	auto_interval_sec := math.Ceil(total_sec / wanted_num_timestamps)
-	if auto_interval_sec < min_interval.Seconds() {
-		return fmt.Sprintf("%vs", math.Ceil(min_interval.Seconds()))
-	} else {
-		return fmt.Sprintf("%vs", math.Ceil(auto_interval_sec))
+	interval_sec := math.Ceil(total_sec / wanted_num_timestamps)
+	if opts.Step > 0 {
+		interval_sec = math.Ceil(opts.Step.Seconds())
+	} else if opts.Points > 0 {
+		interval_sec = math.Ceil(total_sec / float64(opts.Points))
 	}
+	return time.Duration(max(interval_sec, math.Ceil(min_interval.Seconds()))) * time.Second
+}
+
+// format an interval for a fixed_interval date histogram
+func es_interval(interval time.Duration) string {
+	return fmt.Sprintf("%vs", interval.Seconds())
 }
 
 // return pointer to input arg
@@ -894,6 +1507,24 @@ func ptr[T any](in T) *T {
 	return &in
 }
 
+// NewSeries returns a series of n time buckets without data.
+// In all series a time bucket without data is NaN, the handlers write it as null.
+func NewSeries(n int) []float64 {
+	ret := make([]float64, n)
+	for idx := range ret {
+		ret[idx] = math.NaN()
+	}
+	return ret
+}
+
+// the value of a metric aggregate, NaN if the time bucket has no data
+func value_or_missing(in *types.Float64) float64 {
+	if in == nil {
+		return math.NaN()
+	}
+	return float64(*in)
+}
+
 // return a float64 or default value if nil
 func f64(in *types.Float64, def float64) float64 {
 	if in == nil {
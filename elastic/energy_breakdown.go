@@ -0,0 +1,174 @@
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/rs/zerolog"
+
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+// EnergyComponents are the components of the energy breakdown, `node` is the chassis input of the whole node
+var EnergyComponents = []string{"node", "cpu", "gpu", "memory"}
+
+// the energy sensors of the components, they are all in the energy index
+var energySensors = map[string]types.Query{
+	"node": {
+		Bool: &types.BoolQuery{Filter: []types.Query{
+			{Term: map[string]types.TermQuery{"Sensor.ParentalContext": {Value: "Chassis"}}},
+			{Term: map[string]types.TermQuery{"Sensor.PhysicalContext": {Value: "VoltageRegulator"}}},
+			{Term: map[string]types.TermQuery{"Sensor.PhysicalSubContext": {Value: "Input"}}},
+		}},
+	},
+	"cpu":    {Term: map[string]types.TermQuery{"Sensor.PhysicalContext": {Value: "CPU"}}},
+	"gpu":    {Term: map[string]types.TermQuery{"Sensor.PhysicalContext": {Value: "GPU"}}},
+	"memory": {Term: map[string]types.TermQuery{"Sensor.PhysicalContext": {Value: "Memory"}}},
+}
+
+type EnergyBreakdown struct {
+	Warnings
+	Time []time.Time // start of the time buckets
+	// key==node-id, key==component (see EnergyComponents), value==energy in Joule consumed in the time bucket, NaN without data
+	EnergyByNode map[string]map[string][]float64
+}
+
+// GetEnergyBreakdown returns the energy consumed by the nodes and their components, summed over all sensors of a component
+// (e.g. all GPUs of a node). The time buckets are coarse, they only serve to weight the energy with the carbon intensity.
+func (c *Client) GetEnergyBreakdown(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*EnergyBreakdown, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	interval := get_interval(from, to, 15*time.Minute, util.QueryOptions{Points: 100})
+	return query_chunked(ctx, c, nodes, from, to, interval, 1+len(EnergyComponents)*5, func(ctx context.Context, nodes []util.Node) (*EnergyBreakdown, error) {
+		return c.get_energy_breakdown(ctx, nodes, from, to, interval, logger)
+	})
+}
+
+func (c *Client) get_energy_breakdown(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, interval time.Duration, logger *zerolog.Logger) (*EnergyBreakdown, error) {
+	nodesOfInterest := []string{}
+	for _, n := range nodes {
+		n1, _ := strings.CutPrefix(n.Nid, "nid")
+		nodesOfInterest = append(nodesOfInterest, strings.TrimLeft(n1, "0"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	res, err := c.Search().
+		Index(".ds-metrics-facility.telemetry-alps.energy*").
+		Request(&search.Request{
+			Size: ptr(0), // we are only interested in the aggregation results
+			Query: &types.Query{
+				Bool: &types.BoolQuery{
+					Filter: []types.Query{
+						{
+							Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"nid": nodesOfInterest}},
+						}, {
+							Term: map[string]types.TermQuery{"MessageId": {Value: "CrayTelemetry.Energy"}},
+						}, {
+							Range: map[string]types.RangeQuery{
+								"@timestamp": types.DateRangeQuery{
+									Format: ptr("epoch_second"),
+									Gte:    ptr(strconv.FormatInt(from.Unix(), 10)),
+									Lt:     ptr(strconv.FormatInt(to.Unix(), 10)),
+								},
+							},
+						},
+					},
+				},
+			},
+			Aggregations: map[string]types.Aggregations{
+				"timestamps": {
+					DateHistogram: &types.DateHistogramAggregation{
+						Field:          ptr("@timestamp"),
+						FixedInterval:  ptr(es_interval(interval)),
+						ExtendedBounds: histogram_bounds(from, to),
+					},
+					Aggregations: map[string]types.Aggregations{
+						"nodes": {
+							Terms: &types.TermsAggregation{
+								Size:  ptr(len(nodesOfInterest)),
+								Field: ptr("nid"),
+							},
+							Aggregations: map[string]types.Aggregations{
+								"components": {
+									Filters: &types.FiltersAggregation{Filters: energySensors},
+									Aggregations: map[string]types.Aggregations{
+										"sensors": {
+											Terms:        &types.TermsAggregation{Field: ptr("Sensor.Index")},
+											Aggregations: map[string]types.Aggregations{"energy": {Stats: &types.StatsAggregation{Field: ptr("Sensor.Value")}}},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}).Do(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting node's energy breakdown searching in elastic: %w", err)
+	}
+
+	timestampBuckets := res.Aggregations["timestamps"].(*types.DateHistogramAggregate).Buckets.([]types.DateHistogramBucket)
+	logger.Debug().Msgf("Querying energy breakdown from elastic took %vms. Num results in aggregation=%v", res.Took, len(timestampBuckets))
+
+	ret := EnergyBreakdown{EnergyByNode: map[string]map[string][]float64{}}
+	// the last counter value of every sensor, key==node-id/component/sensor index
+	lastCounter := map[string]float64{}
+	for _, timestampBucket := range timestampBuckets {
+		ret.Time = append(ret.Time, time.Unix(timestampBucket.Key/1000, 0))
+		last := len(ret.Time) - 1
+		for _, components := range ret.EnergyByNode {
+			for component := range components {
+				components[component] = append(components[component], math.NaN())
+			}
+		}
+		ret.check_truncated(timestampBucket.Aggregations["nodes"], "nodes")
+		for _, nodeBucket := range timestampBucket.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket) {
+			node_id := "nid" + strings.Repeat("0", 6-len(nodeBucket.Key.(string))) + nodeBucket.Key.(string)
+			componentBuckets := nodeBucket.Aggregations["components"].(*types.FiltersAggregate).Buckets.(map[string]types.FiltersBucket)
+			for component, componentBucket := range componentBuckets {
+				ret.check_truncated(componentBucket.Aggregations["sensors"], component+" sensors")
+				for _, sensorBucket := range terms_buckets(componentBucket.Aggregations["sensors"]) {
+					stats := sensorBucket.aggregations["energy"].(*types.StatsAggregate)
+					if stats.Min == nil || stats.Max == nil {
+						continue
+					}
+					// the counters are monotonic, the consumption within the bucket is the increase since the last bucket with data
+					key := node_id + "/" + component + "/" + sensorBucket.key
+					previous, ok := lastCounter[key]
+					if !ok || float64(*stats.Min) < previous {
+						// first value of the sensor, or the counter was reset
+						previous = float64(*stats.Min)
+					}
+					lastCounter[key] = float64(*stats.Max)
+
+					if _, ok := ret.EnergyByNode[node_id]; !ok {
+						ret.EnergyByNode[node_id] = map[string][]float64{}
+					}
+					if _, ok := ret.EnergyByNode[node_id][component]; !ok {
+						ret.EnergyByNode[node_id][component] = NewSeries(len(ret.Time))
+					}
+					ret.EnergyByNode[node_id][component][last] = SumIgnoringNaN(ret.EnergyByNode[node_id][component][last], float64(*stats.Max)-previous)
+				}
+			}
+		}
+	}
+	return &ret, nil
+}
+
+func (d *EnergyBreakdown) merge(other *EnergyBreakdown) {
+	merge_time(&d.Time, other.Time)
+	merge_nodes(d.EnergyByNode, other.EnergyByNode)
+	d.add_warnings(other.Warnings)
+}
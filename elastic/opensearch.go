@@ -0,0 +1,38 @@
+package elastic
+
+import (
+	"net/url"
+	"os"
+
+	"github.com/elastic/elastic-transport-go/v8/elastictransport"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
+
+	"cscs.ch/hpcdata/util"
+)
+
+// NewOpenSearchClient returns a client issuing the same queries against an OpenSearch cluster.
+// The typed Elasticsearch client refuses to talk to OpenSearch (product check), therefore the search requests
+// are sent over a plain transport. OpenSearch does not understand the versioned elasticsearch media types,
+// which is why the headers are overwritten with plain JSON.
+func NewOpenSearchClient(config *util.Config) *Client {
+	u, err := url.Parse(config.OpenSearch.URL)
+	if err != nil {
+		panic("Failed parsing OpenSearch URL")
+	}
+	tp, err := elastictransport.New(elastictransport.Config{
+		URLs:              []*url.URL{u},
+		Password:          config.OpenSearch.Password,
+		Username:          config.OpenSearch.Username,
+		EnableDebugLogger: true,
+		Logger:            &elastictransport.CurlLogger{Output: os.Stdout, EnableRequestBody: true, EnableResponseBody: true},
+	})
+	if err != nil {
+		panic("Failed creating OpenSearchClient")
+	}
+	newSearch := search.NewSearchFunc(tp)
+	return &Client{func() *search.Search {
+		return newSearch().
+			Header("Content-Type", "application/json").
+			Header("Accept", "application/json")
+	}, max_buckets(config.OpenSearch), config.Timeouts.Elastic}
+}
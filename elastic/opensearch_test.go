@@ -0,0 +1,63 @@
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cscs.ch/hpcdata/util"
+)
+
+func TestOpenSearchHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"content type", "Content-Type", "application/json"},
+		{"accept", "Accept", "application/json"},
+		{"authorization", "Authorization", "Basic dXNlcjpzZWNyZXQ="},
+	}
+
+	var request *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		request, body = r, string(data)
+		// OpenSearch does not send the X-Elastic-Product header
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},"hits":{"total":{"value":1,"relation":"eq"},"hits":[`+
+			`{"_index":"logs-slurm.accounting","_id":"1","_source":{"account":"a-csstaff","jobid":42,"@start":"2024-01-01T00:00:00","@end":"2024-01-01T01:00:00","nodes":"nid000001"}}]}}`)
+	}))
+	defer server.Close()
+
+	config := util.Config{}
+	config.OpenSearch = util.ElasticConfig{URL: server.URL, Username: "user", Password: "secret"}
+	config.Timeouts.Elastic = time.Minute
+	c := NewOpenSearchClient(&config)
+	logger := zerolog.Nop()
+	job, err := c.GetJob(context.Background(), "42", "daint", &logger)
+	if err != nil {
+		t.Fatalf("the search against OpenSearch failed: %v", err)
+	}
+	if job.SlurmId != "42" || job.Account != "csstaff" {
+		t.Errorf("got %+v, expected job 42 of csstaff", job)
+	}
+	if request.Method != "POST" || !strings.HasSuffix(request.URL.Path, "/_search") || !strings.Contains(body, `"daint"`) {
+		t.Errorf("unexpected request %v %v with body %v", request.Method, request.URL.Path, body)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := request.Header.Get(tt.header); got != tt.value {
+				t.Errorf("%v: %q, expected %q", tt.header, got, tt.value)
+			}
+		})
+	}
+}
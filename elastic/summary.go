@@ -0,0 +1,374 @@
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/rs/zerolog"
+
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+// Stats are the mean and maximum of a metric over the whole time window, NaN if there is no data
+type Stats struct {
+	Avg float64
+	Max float64
+}
+
+type GpuSummary struct {
+	GpuIndex       int
+	Utilization    Stats   // %
+	MaxTemperature float64 // °C
+}
+type NodeSummary struct {
+	CpuUser            Stats   // %
+	CpuSystem          Stats   // %
+	CpuBusy            Stats   // %, user + system
+	MinFreeMemory      float64 // kilobytes
+	MinAvailableMemory float64 // kilobytes, the lowest free + cache + buffer memory, i.e. the peak memory usage of the node
+	Gpus               []GpuSummary
+	Energy             float64 // Joule
+	Power              Stats   // Watt
+}
+type JobSummary struct {
+	Warnings
+	SummaryByNode map[string]*NodeSummary // key==node-id
+}
+
+// NewNodeSummary returns a summary without any data
+func NewNodeSummary() *NodeSummary {
+	return &NodeSummary{
+		CpuUser:            Stats{math.NaN(), math.NaN()},
+		CpuSystem:          Stats{math.NaN(), math.NaN()},
+		CpuBusy:            Stats{math.NaN(), math.NaN()},
+		MinFreeMemory:      math.NaN(),
+		MinAvailableMemory: math.NaN(),
+		Energy:             math.NaN(),
+		Power:              Stats{math.NaN(), math.NaN()},
+	}
+}
+
+// the summary of the GPU with index gpuIdx, the GPUs up to gpuIdx are created if they do not exist yet
+func (n *NodeSummary) Gpu(gpuIdx int) *GpuSummary {
+	for len(n.Gpus) <= gpuIdx {
+		n.Gpus = append(n.Gpus, GpuSummary{GpuIndex: len(n.Gpus), Utilization: Stats{math.NaN(), math.NaN()}, MaxTemperature: math.NaN()})
+	}
+	return &n.Gpus[gpuIdx]
+}
+
+// GetJobSummary queries single numbers per node over the whole time window, using stats aggregations instead of date histograms
+func (c *Client) GetJobSummary(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*JobSummary, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	// the whole time window is a single time bucket
+	interval := max(to.Sub(from), time.Second)
+	ret, err := query_chunked(ctx, c, nodes, from, to, interval, 9, func(ctx context.Context, nodes []util.Node) (*JobSummary, error) {
+		return c.get_job_summary(ctx, nodes, from, to, logger)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the sum of user and system and the available memory are not stored, they are summarized from the time series
+	cpu, err := c.GetCpuData(ctx, nodes, from, to, util.QueryOptions{}, logger)
+	if err != nil {
+		return nil, err
+	}
+	ret.add_warnings(cpu.Warnings)
+	memory, err := c.GetMemoryData(ctx, nodes, from, to, util.QueryOptions{}, logger)
+	if err != nil {
+		return nil, err
+	}
+	ret.add_warnings(memory.Warnings)
+	ret.AddSeries(cpu, memory)
+	return ret, nil
+}
+
+// AddSeries sets the values of the summary that are summarized from the cpu and memory time series
+func (d *JobSummary) AddSeries(cpu *CpuData, memory *MemoryData) {
+	for node_id, data := range cpu.CpuByNode {
+		if summary, ok := d.SummaryByNode[node_id]; ok {
+			busy := make([]float64, len(data.User))
+			for idx := range busy {
+				busy[idx] = data.User[idx] + data.System[idx]
+			}
+			summary.CpuBusy = SeriesStats(busy)
+		}
+	}
+	for node_id, data := range memory.MemoryByNode {
+		if summary, ok := d.SummaryByNode[node_id]; ok {
+			for idx := range data.Free {
+				available := data.Free[idx] + data.Cache[idx] + data.Buffer[idx]
+				if !math.IsNaN(available) && (math.IsNaN(summary.MinAvailableMemory) || available < summary.MinAvailableMemory) {
+					summary.MinAvailableMemory = available
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) get_job_summary(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*JobSummary, error) {
+	hostnames := []string{}
+	nids := []string{}
+	for _, n := range nodes {
+		hostnames = append(hostnames, n.Nid)
+		n1, _ := strings.CutPrefix(n.Nid, "nid")
+		nids = append(nids, strings.TrimLeft(n1, "0"))
+	}
+	timeRange := types.Query{
+		Range: map[string]types.RangeQuery{
+			"@timestamp": types.DateRangeQuery{
+				Format: ptr("epoch_second"),
+				Gte:    ptr(strconv.FormatInt(from.Unix(), 10)),
+				Lt:     ptr(strconv.FormatInt(to.Unix(), 10)),
+			},
+		},
+	}
+	metricFilter := func(name string) *types.Query {
+		return &types.Query{Term: map[string]types.TermQuery{"metric.name": {Value: name}}}
+	}
+
+	ret := JobSummary{SummaryByNode: map[string]*NodeSummary{}}
+	for _, n := range nodes {
+		ret.SummaryByNode[n.Nid] = NewNodeSummary()
+	}
+	// the telemetry indices without hostname report the node as numeric nid
+	nodeSummary := func(nid string) *NodeSummary {
+		node_id := "nid" + strings.Repeat("0", 6-len(nid)) + nid
+		if _, ok := ret.SummaryByNode[node_id]; !ok {
+			ret.SummaryByNode[node_id] = NewNodeSummary()
+		}
+		return ret.SummaryByNode[node_id]
+	}
+
+	// cpu, memory and GPU utilization
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	res, err := c.Search().
+		Index(".ds-metrics-facility.telemetry-alps.node*").
+		Request(&search.Request{
+			Size: ptr(0), // we are only interested in the aggregation results
+			Query: &types.Query{
+				Bool: &types.BoolQuery{
+					Filter: []types.Query{
+						{
+							Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"metric.dimensions.hostname": hostnames}},
+						}, {
+							Term: map[string]types.TermQuery{"data_stream.namespace": {Value: "alps.node"}},
+						}, {
+							Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"metric.name": []string{
+								"cray_storage.cray_vmstat.cpu_us",
+								"cray_storage.cray_vmstat.cpu_sy",
+								"cray_storage.cray_vmstat.mem_free",
+								"cray_storage.dcgm.gpu_utilization",
+							}}},
+						},
+						timeRange,
+					},
+				},
+			},
+			Aggregations: map[string]types.Aggregations{
+				"nodes": {
+					Terms: &types.TermsAggregation{
+						Size:  ptr(len(hostnames)),
+						Field: ptr("metric.dimensions.hostname"),
+					},
+					Aggregations: map[string]types.Aggregations{
+						"user": {
+							Filter:       metricFilter("cray_storage.cray_vmstat.cpu_us"),
+							Aggregations: map[string]types.Aggregations{"value": {Stats: &types.StatsAggregation{Field: ptr("metric.value")}}},
+						},
+						"system": {
+							Filter:       metricFilter("cray_storage.cray_vmstat.cpu_sy"),
+							Aggregations: map[string]types.Aggregations{"value": {Stats: &types.StatsAggregation{Field: ptr("metric.value")}}},
+						},
+						"free": {
+							Filter:       metricFilter("cray_storage.cray_vmstat.mem_free"),
+							Aggregations: map[string]types.Aggregations{"value": {Min: &types.MinAggregation{Field: ptr("metric.value")}}},
+						},
+						"gpu": {
+							Filter: metricFilter("cray_storage.dcgm.gpu_utilization"),
+							Aggregations: map[string]types.Aggregations{
+								"gpu_idx": {
+									Terms:        &types.TermsAggregation{Field: ptr("metric.dimensions.gpu_id")},
+									Aggregations: map[string]types.Aggregations{"value": {Stats: &types.StatsAggregation{Field: ptr("metric.value")}}},
+								},
+							},
+						},
+					},
+				},
+			},
+		}).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting node's summary, when searching in elastic: %w", err)
+	}
+	logger.Debug().Msgf("Querying node summary from elastic took %vms", res.Took)
+	ret.check_truncated(res.Aggregations["nodes"], "nodes")
+	for _, nodeBucket := range res.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket) {
+		node_id := nodeBucket.Key.(string)
+		summary, ok := ret.SummaryByNode[node_id]
+		if !ok {
+			summary = NewNodeSummary()
+			ret.SummaryByNode[node_id] = summary
+		}
+		summary.CpuUser = stats_value(nodeBucket.Aggregations["user"].(*types.FilterAggregate).Aggregations["value"])
+		summary.CpuSystem = stats_value(nodeBucket.Aggregations["system"].(*types.FilterAggregate).Aggregations["value"])
+		summary.MinFreeMemory = value_or_missing(aggregate_value(nodeBucket.Aggregations["free"].(*types.FilterAggregate).Aggregations["value"]))
+		gpuAgg := nodeBucket.Aggregations["gpu"].(*types.FilterAggregate).Aggregations["gpu_idx"]
+		ret.check_truncated(gpuAgg, "GPUs")
+		for _, gpuBucket := range gpuAgg.(*types.LongTermsAggregate).Buckets.([]types.LongTermsBucket) {
+			summary.Gpu(int(gpuBucket.Key)).Utilization = stats_value(gpuBucket.Aggregations["value"])
+		}
+	}
+
+	// GPU temperature
+	res, err = c.Search().
+		Index(".ds-metrics-facility.telemetry-alps*").
+		Request(&search.Request{
+			Size: ptr(0), // we are only interested in the aggregation results
+			Query: &types.Query{
+				Bool: &types.BoolQuery{
+					Filter: []types.Query{
+						{
+							Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"nid": nids}},
+						}, {
+							Term: map[string]types.TermQuery{"Sensor.PhysicalContext": {Value: "GPU"}},
+						}, {
+							Term: map[string]types.TermQuery{"MessageId": {Value: "CrayTelemetry.Temperature"}},
+						},
+						timeRange,
+					},
+				},
+			},
+			Aggregations: map[string]types.Aggregations{
+				"nodes": {
+					Terms: &types.TermsAggregation{
+						Size:  ptr(len(nids)),
+						Field: ptr("nid"),
+					},
+					Aggregations: map[string]types.Aggregations{
+						"gpu_idx": {
+							Terms:        &types.TermsAggregation{Field: ptr("Sensor.Index")},
+							Aggregations: map[string]types.Aggregations{"temperature": {Max: &types.MaxAggregation{Field: ptr("Sensor.Value")}}},
+						},
+					},
+				},
+			},
+		}).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting GPU temperature summary, when searching in elastic: %w", err)
+	}
+	logger.Debug().Msgf("Querying GPU temperature summary from elastic took %vms", res.Took)
+	ret.check_truncated(res.Aggregations["nodes"], "nodes")
+	for _, nodeBucket := range res.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket) {
+		summary := nodeSummary(nodeBucket.Key.(string))
+		ret.check_truncated(nodeBucket.Aggregations["gpu_idx"], "GPUs")
+		for _, gpuBucket := range nodeBucket.Aggregations["gpu_idx"].(*types.LongTermsAggregate).Buckets.([]types.LongTermsBucket) {
+			summary.Gpu(int(gpuBucket.Key)).MaxTemperature = value_or_missing(aggregate_value(gpuBucket.Aggregations["temperature"]))
+		}
+	}
+
+	// energy and power, the energy is a counter, i.e. the consumed energy is the difference of its maximum and minimum
+	for _, sensor := range []string{"Energy", "Power"} {
+		res, err = c.Search().
+			Index(fmt.Sprintf(".ds-metrics-facility.telemetry-alps.%v*", strings.ToLower(sensor))).
+			Request(&search.Request{
+				Size: ptr(0), // we are only interested in the aggregation results
+				Query: &types.Query{
+					Bool: &types.BoolQuery{
+						Filter: []types.Query{
+							{
+								Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"nid": nids}},
+							}, {
+								Term: map[string]types.TermQuery{"Sensor.ParentalContext": {Value: "Chassis"}},
+							}, {
+								Term: map[string]types.TermQuery{"Sensor.PhysicalContext": {Value: "VoltageRegulator"}},
+							}, {
+								Term: map[string]types.TermQuery{"Sensor.PhysicalSubContext": {Value: "Input"}},
+							}, {
+								Term: map[string]types.TermQuery{"MessageId": {Value: "CrayTelemetry." + sensor}},
+							},
+							timeRange,
+						},
+					},
+				},
+				Aggregations: map[string]types.Aggregations{
+					"nodes": {
+						Terms: &types.TermsAggregation{
+							Size:  ptr(len(nids)),
+							Field: ptr("nid"),
+						},
+						Aggregations: map[string]types.Aggregations{
+							"value": {Stats: &types.StatsAggregation{Field: ptr("Sensor.Value")}},
+						},
+					},
+				},
+			}).Do(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting %v summary, when searching in elastic: %w", strings.ToLower(sensor), err)
+		}
+		logger.Debug().Msgf("Querying %v summary from elastic took %vms", strings.ToLower(sensor), res.Took)
+		ret.check_truncated(res.Aggregations["nodes"], "nodes")
+		for _, nodeBucket := range res.Aggregations["nodes"].(*types.StringTermsAggregate).Buckets.([]types.StringTermsBucket) {
+			summary := nodeSummary(nodeBucket.Key.(string))
+			stats := nodeBucket.Aggregations["value"].(*types.StatsAggregate)
+			if sensor == "Energy" {
+				if stats.Min != nil && stats.Max != nil {
+					summary.Energy = float64(*stats.Max - *stats.Min)
+				}
+			} else {
+				summary.Power = stats_value(stats)
+			}
+		}
+	}
+	return &ret, nil
+}
+
+func (d *JobSummary) merge(other *JobSummary) {
+	merge_nodes(d.SummaryByNode, other.SummaryByNode)
+	d.add_warnings(other.Warnings)
+}
+
+// the mean and maximum of a stats aggregate
+func stats_value(agg types.Aggregate) Stats {
+	if stats, ok := agg.(*types.StatsAggregate); ok {
+		return Stats{value_or_missing(stats.Avg), value_or_missing(stats.Max)}
+	}
+	return Stats{math.NaN(), math.NaN()}
+}
+
+// SeriesStats computes the Stats of a time series, time buckets without data (NaN) are ignored
+func SeriesStats(data []float64) Stats {
+	sum, count, maximum := 0.0, 0, math.NaN()
+	for _, v := range data {
+		if math.IsNaN(v) {
+			continue
+		}
+		sum += v
+		count++
+		if math.IsNaN(maximum) || v > maximum {
+			maximum = v
+		}
+	}
+	if count == 0 {
+		return Stats{math.NaN(), math.NaN()}
+	}
+	return Stats{sum / float64(count), maximum}
+}
+
+// SumIgnoringNaN adds v to sum, where a NaN sum means that there is no value yet
+func SumIgnoringNaN(sum, v float64) float64 {
+	if math.IsNaN(sum) {
+		return v
+	}
+	return sum + v
+}
@@ -0,0 +1,38 @@
+import os
+import sys
+import yaml
+
+import matplotlib.pyplot as plt
+import requests
+
+from common import Config, generate_token
+
+if __name__ == '__main__':
+    if len(sys.argv) < 3:
+        sys.exit(f'usage: {sys.argv[0]} <baseline jobid> <jobid>...')
+    with open(os.path.join(os.path.dirname(__file__), 'config.yaml')) as f:
+        config: Config = yaml.safe_load(f)
+        cluster = config['cluster']
+        token = generate_token(config)
+        auth_header = {'Authorization': f'Bearer {token}'}
+        r = requests.get(f'{config['base_url']}/compare/{cluster}', params={'jobs': ','.join(sys.argv[1:])}, headers=auth_header)
+        r.raise_for_status()
+        result = r.json()
+        for warning in result.get('warnings', []):
+            print(f'warning: {warning}')
+
+        for jobid, delta in result['summary_delta'].items():
+            print(f'{jobid}: wall time {delta['wall_time']:+d} s, energy {delta['energy'] or 0:+.0f} Joule, mean cpu usage {delta['cpu_user']['avg'] or 0:+.1f} %')
+
+        fig, axes = plt.subplots(len(result['metrics']), sharex=True)
+        fig.set_figwidth(19)
+        fig.set_figheight(10)
+        for ax, (metric, data) in zip(axes, sorted(result['metrics'].items())):
+            for jobid, series in data['series'].items():
+                ax.plot(result['time'], series, label=jobid)
+            ax.set_ylabel(f'{metric} [{data['unit']}]')
+            ax.grid(True)
+        axes[-1].set_xlabel('seconds since the job\'s start')
+        axes[0].legend()
+        fig.tight_layout()
+        plt.show()
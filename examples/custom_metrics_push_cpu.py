@@ -19,7 +19,6 @@ def get_cpu_jiffies():
 
 if __name__ == '__main__':
     hostname = open('/etc/hostname').read().strip()
-    xname = open('/etc/cray/xname').read().strip()
     metric_name = 'utilization'
     context = 'cpu:all'
     cluster = os.environ['CLUSTER_NAME']
@@ -28,10 +27,12 @@ if __name__ == '__main__':
     data = {
         'name': metric_name,
         'context': context,
-        'xname': xname,
         'timestamp': datetime.datetime.now().timestamp(),
         'value': '',
     }
+    # optional, the server looks the xname up in its node inventory
+    if os.path.exists('/etc/cray/xname'):
+        data['xname'] = open('/etc/cray/xname').read().strip()
     url = f'https://hpcdata.vserverli.de/metrics/{cluster}/{job_id}/{hostname}/custom'
 
     # Get initial jiffies
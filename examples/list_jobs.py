@@ -0,0 +1,27 @@
+import datetime
+import os
+import yaml
+
+import requests
+
+from common import Config, generate_token
+
+if __name__ == '__main__':
+    with open(os.path.join(os.path.dirname(__file__), 'config.yaml')) as f:
+        config: Config = yaml.safe_load(f)
+        cluster = config['cluster']
+        token = generate_token(config)
+        auth_header = {'Authorization': f'Bearer {token}'}
+        page = 1
+        while True:
+            r = requests.get(f'{config['base_url']}/jobs/{cluster}', params={'page': page}, headers=auth_header)
+            r.raise_for_status()
+            for warning in r.json().get('warnings', []):
+                print(f'warning: {warning}')
+            for job in r.json()['jobs']:
+                start = datetime.datetime.fromtimestamp(job['start'])
+                state = 'finished' if job['finished'] else 'running'
+                print(f'{job['jobid']:>10} {job['account']:<12} {start} {state:<8} {len(job['nodes'])} node(s)')
+            if page * r.json()['per_page'] >= r.json()['total']:
+                break
+            page += 1
@@ -15,7 +15,8 @@ if __name__ == '__main__':
         cluster = config['cluster']
         token = generate_token(config)
         auth_header = {'Authorization': f'Bearer {token}'}
-        r = requests.get(f'{config['base_url']}/metrics/{cluster}/{jobid}/node/cpu', headers=auth_header)
+        # the plot cannot show more points than the screen has pixels, the server keeps the peaks when downsampling
+        r = requests.get(f'{config['base_url']}/metrics/{cluster}/{jobid}/node/cpu', params={'max_points': 2000}, headers=auth_header)
         r.raise_for_status()
 
         time = [datetime.datetime.fromtimestamp(x) for x in r.json()['time']]
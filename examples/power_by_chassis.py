@@ -0,0 +1,36 @@
+import datetime
+import os
+import yaml
+
+import matplotlib.pyplot as plt
+import requests
+
+from common import Config, generate_token
+
+if __name__ == '__main__':
+    with open(os.path.join(os.path.dirname(__file__), 'config.yaml')) as f:
+        config: Config = yaml.safe_load(f)
+        jobid = config['jobid']
+        cluster = config['cluster']
+        token = generate_token(config)
+        auth_header = {'Authorization': f'Bearer {token}'}
+        # the total power of the job's nodes per chassis, requires the node inventory of the cluster
+        r = requests.get(f'{config['base_url']}/metrics/{cluster}/{jobid}/node/power', params={'group_by': 'chassis'}, headers=auth_header)
+        r.raise_for_status()
+        for warning in r.json().get('warnings', []):
+            print(f'Warning: {warning}')
+
+        time = [datetime.datetime.fromtimestamp(x) for x in r.json()['time']]
+
+        fig, ax = plt.subplots()
+        fig.set_figwidth(19)
+        fig.set_figheight(10)
+
+        for chassis, group in sorted(r.json()['groups'].items()):
+            ax.plot(time, group['power'], label=f'{chassis} ({len(group["nodes"])} nodes)')
+
+        ax.set_ylabel('Power [Watt]')
+        ax.grid(True)
+        fig.legend()
+        fig.tight_layout()
+        plt.show()
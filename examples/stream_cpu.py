@@ -0,0 +1,38 @@
+import datetime
+import json
+import os
+import yaml
+
+import requests
+
+from common import Config, generate_token
+
+if __name__ == '__main__':
+    with open(os.path.join(os.path.dirname(__file__), 'config.yaml')) as f:
+        config: Config = yaml.safe_load(f)
+        jobid = config['jobid']
+        cluster = config['cluster']
+        token = generate_token(config)
+        auth_header = {'Authorization': f'Bearer {token}'}
+        r = requests.get(f'{config['base_url']}/metrics/{cluster}/{jobid}/node/cpu/stream', headers=auth_header, stream=True)
+        r.raise_for_status()
+
+        # every event repeats the last time bucket of the previous one, the newer value replaces the older one
+        cpu: dict[str, dict[int, float | None]] = {}
+        event = ''
+        for line in r.iter_lines(decode_unicode=True):
+            if line.startswith('event: '):
+                event = line.removeprefix('event: ')
+            elif line.startswith('data: '):
+                data = json.loads(line.removeprefix('data: '))
+                if event == 'data':
+                    for nodeid, nodeData in data['nodes'].items():
+                        for t, user, system in zip(data['time'], nodeData['user'], nodeData['system']):
+                            cpu.setdefault(nodeid, {})[t] = None if user is None or system is None else user+system
+                    for nodeid, values in sorted(cpu.items()):
+                        t = max(values)
+                        print(f'{datetime.datetime.fromtimestamp(t)} {nodeid} cpu={values[t]}%')
+                elif event == 'error':
+                    print(f'error: {data["message"]}')
+                elif event == 'end':
+                    print('The job has finished')
@@ -1,6 +1,7 @@
 package firecrest
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -29,7 +30,7 @@ type JobTaskTime struct {
 	Elapsed   int `json:"elapsed"`
 	Start     int `json:"start"`
 	End       int `json:"end"`   // 0 if not finished yet
-	Limit     int `json:"limit"` // 0 if not set
+	Limit     int `json:"limit"` // in minutes, 0 if not set
 	Suspended int `json:"suspeended"`
 }
 type JobTask struct {
@@ -37,6 +38,7 @@ type JobTask struct {
 	Name   string        `json:"name"`
 	Status JobTaskStatus `json:"status"`
 	Time   JobTaskTime   `json:"time"`
+	Nodes  string        `json:"nodes"` // empty if not provided
 }
 type Job struct {
 	Account        string        `json:"account"`
@@ -63,44 +65,59 @@ type Client struct {
 	baseURL       string
 	system        string
 	authorization string
+	timeout       time.Duration // of a single request
 }
 
-func NewClient(url, system, authorization string) *Client {
+func NewClient(url, system, authorization string, timeout time.Duration) *Client {
 	return &Client{
 		baseURL:       url,
 		system:        system,
 		authorization: authorization,
+		timeout:       timeout,
 	}
 }
 
-func (f *Client) UserInfo() (UserInfo, error) {
+func (f *Client) UserInfo(ctx context.Context) (UserInfo, error) {
 	ret := UserInfo{}
-	err := f._get(
+	err := f._get(ctx,
 		fmt.Sprintf("status/%v/userinfo", f.system),
 		&ret,
 	)
 	return ret, err
 }
 
-func (f *Client) Job(jobid string) (Job, error) {
+// Job returns all allocations of the job id, e.g. the tasks of a job array or the components of a heterogeneous job
+func (f *Client) Job(ctx context.Context, jobid string) ([]Job, error) {
 	ret := Jobs{}
-	err := f._get(
+	err := f._get(ctx,
 		fmt.Sprintf("compute/%v/jobs/%v", f.system, jobid),
 		&ret,
 	)
 	if err != nil {
-		return Job{}, err
+		return nil, err
 	}
-	if len(ret.Jobs) != 1 {
-		return Job{}, fmt.Errorf("Firecrest did not return exactly one job")
+	if len(ret.Jobs) == 0 {
+		return nil, fmt.Errorf("Firecrest did not return any job")
 	}
 
-	return ret.Jobs[0], err
+	return ret.Jobs, err
 }
 
-func (f *Client) _get(endpoint string, ret any) error {
+// Jobs returns the caller's jobs that are currently in the queue or running
+func (f *Client) Jobs(ctx context.Context) ([]Job, error) {
+	ret := Jobs{}
+	err := f._get(ctx,
+		fmt.Sprintf("compute/%v/jobs", f.system),
+		&ret,
+	)
+	return ret.Jobs, err
+}
+
+func (f *Client) _get(ctx context.Context, endpoint string, ret any) error {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
 	start := time.Now()
-	resp, err := util.DoRequest("GET",
+	resp, err := util.DoRequest(ctx, "GET",
 		fmt.Sprintf("%v/%v", f.baseURL, endpoint),
 		map[string]string{"Authorization": f.authorization},
 		nil)
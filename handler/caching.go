@@ -16,8 +16,10 @@ import (
 
 var redis_client *redis.Client = nil
 var redis_lock *redsync.Redsync = nil
+var redis_timeout time.Duration
 
-func InitRedis(cfg util.RedisConfig) {
+func InitRedis(cfg util.RedisConfig, timeout time.Duration) {
+	redis_timeout = timeout
 	redis_client = redis.NewClient(&redis.Options{
 		Addr:     cfg.Address,
 		Password: cfg.Password,
@@ -30,10 +32,12 @@ func InitRedis(cfg util.RedisConfig) {
 }
 
 // getter must return a pointer to the type of interest + a caching duration + error if no caching should be done
-func get_cached[T any](cache_key string, logger *zerolog.Logger, getter func() (*T, time.Duration, error)) (*T, error) {
+// a slow or unreachable redis is treated like a cache miss
+func get_cached[T any](ctx context.Context, cache_key string, logger *zerolog.Logger, getter func() (*T, time.Duration, error)) (*T, error) {
 	logger.Debug().Msgf("Requesting cache data for cache_key=%v", cache_key)
-	ctx := context.Background()
-	if cached_job_data, err := redis_client.Get(ctx, cache_key).Bytes(); err == nil {
+	get_ctx, cancel := context.WithTimeout(ctx, redis_timeout)
+	defer cancel()
+	if cached_job_data, err := redis_client.Get(get_ctx, cache_key).Bytes(); err == nil {
 		logger.Debug().Msgf("Found cached data for cache_key=%v", cache_key)
 		buf := bytes.NewBuffer(cached_job_data)
 		var ret T
@@ -54,7 +58,11 @@ func get_cached[T any](cache_key string, logger *zerolog.Logger, getter func() (
 			logger.Error().Err(err).Msgf("Failed encoding cache value with gob encoding")
 		} else {
 			logger.Debug().Msgf("Storing cached data for cache_key=%v", cache_key)
-			redis_client.Set(ctx, cache_key, buf.Bytes(), cache_timeout)
+			set_ctx, cancel := context.WithTimeout(ctx, redis_timeout)
+			defer cancel()
+			if err := redis_client.Set(set_ctx, cache_key, buf.Bytes(), cache_timeout).Err(); err != nil {
+				logger.Warn().Err(err).Msgf("Failed storing cached data for cache_key=%v", cache_key)
+			}
 		}
 		return ret, nil
 	}
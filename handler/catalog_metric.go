@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+type catalogMetric struct {
+	config   *util.Config
+	backends backend.Backends
+	metric   util.CatalogMetric
+}
+
+func GetCatalogMetricHandler(config *util.Config, backends backend.Backends, metric util.CatalogMetric) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(catalogMetric{config, backends, metric})
+}
+
+/*
+Returns for a metric without split_field
+
+	{
+		"time": [int] <epoch-time>,
+		"nodes": {
+			"<node_id>": {"<name>": []float, "<name>_unit": string},
+		},
+	}
+
+and for a metric with split_field one entry per split value
+
+	{
+		"time": [int] <epoch-time>,
+		"nodes": {
+			"<node_id>": [{"<split_field>": string, "<name>": []float, "<name>_unit": string}],
+		},
+	}
+
+Both contain "warnings": [string], if the result might be incomplete.
+*/
+func (h catalogMetric) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
+
+	logger.Debug().Msgf("Passed all security checks to fetch catalog metric %v for job=%+v in the time window from=%v to=%v", h.metric.Path, job, from, to)
+
+	catalogBackend, ok := get_backend(r, h.backends).(backend.CatalogBackend)
+	if !ok {
+		pie(logger.Warn, condition_error{fmt.Sprintf("The metric %v is not available on this system", h.metric.Path)}, "", http.StatusNotImplemented)
+	}
+
+	nodes := get_nodes(r, job)
+	fill := get_fill(r)
+	opts := get_query_options(r, from, to, util.Aggregations)
+	metricData, err := catalogBackend.GetCatalogMetric(r.Context(), h.metric, nodes, from, to, opts, logger)
+	pie(logger.Error, err, fmt.Sprintf("Failed getting %v data", h.metric.Path), http.StatusInternalServerError)
+
+	all := [][]float64{}
+	for _, series := range metricData.MetricByNode {
+		for _, s := range series {
+			all = append(all, s.Data)
+		}
+	}
+	ds := get_downsampling(r, len(metricData.Time), all)
+	unitKey := fmt.Sprintf("%v_unit", h.metric.Name)
+	ret := struct {
+		Time     []epochTime       `json:"time"`
+		Nodes    map[string]any    `json:"nodes"`
+		Xnames   map[string]string `json:"xnames"`
+		Warnings []string          `json:"warnings,omitempty"`
+	}{as_epoch_array(sample(ds, metricData.Time)), map[string]any{}, xnames(nodes), metricData.Warnings.Warnings}
+	for nid, series := range metricData.MetricByNode {
+		if h.metric.SplitField == "" {
+			ret.Nodes[nid] = map[string]any{h.metric.Name: fill.series(sample(ds, series[0].Data)), unitKey: h.metric.Unit}
+		} else {
+			nodeSeries := []map[string]any{}
+			for _, s := range series {
+				nodeSeries = append(nodeSeries, map[string]any{h.metric.SplitField: s.Split, h.metric.Name: fill.series(sample(ds, s.Data)), unitKey: h.metric.Unit})
+			}
+			ret.Nodes[nid] = nodeSeries
+		}
+	}
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+// the maximum number of jobs of a comparison
+const max_compared_jobs = 10
+
+// the number of time buckets of the longest compared job, if neither `step` nor `points` is given
+const default_compare_points = 1000
+
+// the compared metrics and their units, see jobComparison.Get
+var compared_metrics = map[string]string{
+	"cpu":             "%",
+	"gpu_utilization": "%",
+	"memory_free":     "kilobytes",
+	"power":           "Watt",
+}
+
+type jobComparison struct {
+	config   *util.Config
+	backends backend.Backends
+}
+
+func GetCompareHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(jobComparison{config, backends})
+}
+
+/*
+	Returns the same metrics of several jobs, e.g. reruns of a workload, aligned on a relative time axis that starts at
+	each job's start, and their differences to the first job
+
+	{
+		"time": [int] <seconds since the job's start>,
+		"jobs": [{"jobid": string, "start": int <epoch-time>, "end": int <epoch-time>, "nodes": int, "finished": bool}],
+		"metrics": {
+			"<metric>": {
+				"unit": string,
+				"series": {"<jobid>": [float]},
+				"delta": {"<jobid>": [float] <difference to the first job, which is omitted>},
+			},
+		},
+		"summary": {"<jobid>": {<the fields of `job` and `wall_time` of /metrics/{system_name}/{job_id}/summary>}},
+		"summary_delta": {"<jobid>": {<same as summary, difference to the first job, which is omitted>}},
+		"units": {"<quantity>": string} <units of the summary>,
+	}
+
+	The metrics are aggregated over the nodes of a job: `cpu` is the mean usage (user + system), `gpu_utilization` the
+	mean over all GPUs, `memory_free` the mean free memory and `power` the sum over the nodes. A time bucket without
+	data, e.g. after a shorter job has ended, is null, and so is its delta.
+
+	Queries:
+		jobs: comma-separated list of 2 to 10 job ids, the first job is the baseline of the deltas
+		step, points: the time resolution, which is the same for all jobs and metrics, the default is 1000 points over the
+			longest job. It is raised to the coarsest resolution at which the backend stores any of the metrics.
+		fill: see the time series endpoints, it does not apply to the deltas
+		component, job_step: apply to all jobs
+*/
+func (h jobComparison) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	if query := r.URL.Query(); query.Has("from") || query.Has("to") {
+		pie(logger.Warn, herr("The queries `from` and `to` are not supported, the jobs are compared over their whole runtime", fmt.Sprintf("from=%v, to=%v", query.Get("from"), query.Get("to"))), "", http.StatusBadRequest)
+	}
+	jobids := strings.Split(r.URL.Query().Get("jobs"), ",")
+	if len(jobids) < 2 || len(jobids) > max_compared_jobs {
+		pie(logger.Warn, herr(fmt.Sprintf("The query `jobs` must list between 2 and %v job ids", max_compared_jobs), fmt.Sprintf("jobs=%v", jobids)), "", http.StatusBadRequest)
+	}
+	for idx, jobid := range jobids {
+		if jobid == "" || slices.Contains(jobids[:idx], jobid) {
+			pie(logger.Warn, herr("The query `jobs` must list distinct, non-empty job ids", fmt.Sprintf("jobs=%v", jobids)), "", http.StatusBadRequest)
+		}
+	}
+
+	jobs := []*util.Job{}
+	longest := time.Duration(0)
+	for _, jobid := range jobids {
+		// the access is checked for every job as if it was requested on its own
+		job, _, _ := panic_if_no_access(mux.SetURLVars(r, map[string]string{"system_name": mux.Vars(r)["system_name"], "job_id": jobid}), h.backends, h.config)
+		jobs = append(jobs, job)
+		longest = max(longest, job.End.Sub(job.Start))
+	}
+
+	logger.Debug().Msgf("Passed all security checks to compare the jobs=%v", jobids)
+
+	// the time buckets must have the same size for all jobs, the backend rounds them to seconds
+	opts := get_query_options(r, time.Time{}, time.Time{}.Add(longest), nil)
+	step := opts.Step
+	if step == 0 {
+		step = longest / time.Duration(cmp.Or(opts.Points, default_compare_points))
+	}
+	opts = util.QueryOptions{Step: max(time.Second, step.Round(time.Second))}
+	fill := get_fill(r)
+
+	type Job struct {
+		JobId    string    `json:"jobid"`
+		Start    epochTime `json:"start"`
+		End      epochTime `json:"end"`
+		Nodes    int       `json:"nodes"`
+		Finished bool      `json:"finished"`
+	}
+	type Metric struct {
+		Unit   string                    `json:"unit"`
+		Series map[string]nullableSeries `json:"series"`
+		Delta  map[string]nullableSeries `json:"delta"`
+	}
+	type Summary struct {
+		WallTime int64 `json:"wall_time"`
+		summaryValues
+	}
+	ret := struct {
+		Time         []int64            `json:"time"`
+		Jobs         []Job              `json:"jobs"`
+		Metrics      map[string]Metric  `json:"metrics"`
+		Summary      map[string]Summary `json:"summary"`
+		SummaryDelta map[string]Summary `json:"summary_delta"`
+		Units        map[string]string  `json:"units"`
+		Warnings     []string           `json:"warnings,omitempty"`
+	}{[]int64{}, []Job{}, map[string]Metric{}, map[string]Summary{}, map[string]Summary{}, summary_units, nil}
+
+	// the backend raises the step to the minimum interval of a metric, but all metrics must share the same step
+	series, warnings := h.fetch_all(r, jobs, opts)
+	if step := common_step(series, opts.Step); step != opts.Step {
+		opts.Step = step
+		series, warnings = h.fetch_all(r, jobs, opts)
+	}
+	ret.Warnings = warnings
+
+	for _, job := range jobs {
+		summary := Summary{int64(job.End.Sub(job.Start).Seconds()), h.summary(r, job)}
+		ret.Summary[job.SlurmId] = summary
+		if job != jobs[0] {
+			base := ret.Summary[jobs[0].SlurmId]
+			ret.SummaryDelta[job.SlurmId] = Summary{summary.WallTime - base.WallTime, summary_delta(summary.summaryValues, base.summaryValues)}
+		}
+		ret.Jobs = append(ret.Jobs, Job{job.SlurmId, epochTime{job.Start}, epochTime{job.End}, len(job.Nodes), job.Finished})
+	}
+
+	// the first time bucket of a job can start before the job, i.e. a job can span one more time bucket
+	n := int((longest+opts.Step-1)/opts.Step) + 1
+	for _, job_series := range series {
+		for _, s := range job_series {
+			n = max(n, len(s.time))
+		}
+	}
+	for idx := range n {
+		ret.Time = append(ret.Time, int64(idx)*int64(opts.Step.Seconds()))
+	}
+	for metric, unit := range compared_metrics {
+		m := Metric{unit, map[string]nullableSeries{}, map[string]nullableSeries{}}
+		base := series[0][metric].align(opts.Step, n)
+		for idx, job := range jobs {
+			aligned := series[idx][metric].align(opts.Step, n)
+			m.Series[job.SlurmId] = fill.series(aligned)
+			if idx > 0 {
+				delta := make(nullableSeries, n)
+				for bucket := range delta {
+					delta[bucket] = aligned[bucket] - base[bucket]
+				}
+				m.Delta[job.SlurmId] = delta
+			}
+		}
+		ret.Metrics[metric] = m
+	}
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+// a compared metric of a job, aggregated over its nodes
+type comparedSeries struct {
+	time []time.Time
+	data []float64
+}
+
+// fetches the compared metrics of all jobs, with the warnings prefixed by the job id
+func (h jobComparison) fetch_all(r *http.Request, jobs []*util.Job, opts util.QueryOptions) ([]map[string]comparedSeries, []string) {
+	ret, warnings := []map[string]comparedSeries{}, []string{}
+	for _, job := range jobs {
+		metrics, job_warnings := h.fetch(r, job, opts)
+		ret = append(ret, metrics)
+		for _, warning := range job_warnings {
+			warnings = append(warnings, fmt.Sprintf("%v: %v", job.SlurmId, warning))
+		}
+	}
+	return ret, warnings
+}
+
+// the largest step of the series, i.e. the step that the backend uses for all metrics when it is requested
+func common_step(series []map[string]comparedSeries, step time.Duration) time.Duration {
+	ret := step
+	for _, job_series := range series {
+		for _, s := range job_series {
+			if len(s.time) > 1 {
+				ret = max(ret, s.time[1].Sub(s.time[0]))
+			}
+		}
+	}
+	return ret
+}
+
+// fetches the compared metrics of the job over its whole runtime, key==metric
+func (h jobComparison) fetch(r *http.Request, job *util.Job, opts util.QueryOptions) (map[string]comparedSeries, []string) {
+	logger := logging.GetReqLogger(r)
+	ctx := r.Context()
+	metrics_backend := get_backend(r, h.backends)
+	ret := map[string]comparedSeries{}
+	warnings := []string{}
+
+	cpuData, err := metrics_backend.GetCpuData(ctx, job.Nodes, job.Start, job.End, opts, logger)
+	pie(logger.Error, err, fmt.Sprintf("Failed getting cpu data of job %v", job.SlurmId), http.StatusInternalServerError)
+	busy := [][]float64{}
+	for _, c := range cpuData.CpuByNode {
+		node := make([]float64, len(c.User))
+		for idx := range node {
+			node[idx] = c.User[idx] + c.System[idx]
+		}
+		busy = append(busy, node)
+	}
+	ret["cpu"] = comparedSeries{cpuData.Time, combine_series(busy, len(cpuData.Time), mean)}
+	warnings = append(warnings, cpuData.Warnings.Warnings...)
+
+	dcgmData, err := metrics_backend.GetDcgmData(ctx, job.Nodes, job.Start, job.End, "gpu_utilization", opts, logger)
+	pie(logger.Error, err, fmt.Sprintf("Failed getting GPU utilization of job %v", job.SlurmId), http.StatusInternalServerError)
+	gpus := [][]float64{}
+	for _, node := range dcgmData.MetricByNode {
+		for _, gpu := range node {
+			gpus = append(gpus, gpu.Data)
+		}
+	}
+	ret["gpu_utilization"] = comparedSeries{dcgmData.Time, combine_series(gpus, len(dcgmData.Time), mean)}
+	warnings = append(warnings, dcgmData.Warnings.Warnings...)
+
+	memoryData, err := metrics_backend.GetMemoryData(ctx, job.Nodes, job.Start, job.End, opts, logger)
+	pie(logger.Error, err, fmt.Sprintf("Failed getting memory data of job %v", job.SlurmId), http.StatusInternalServerError)
+	free := [][]float64{}
+	for _, m := range memoryData.MemoryByNode {
+		free = append(free, m.Free)
+	}
+	ret["memory_free"] = comparedSeries{memoryData.Time, combine_series(free, len(memoryData.Time), mean)}
+	warnings = append(warnings, memoryData.Warnings.Warnings...)
+
+	chassisPower, err := metrics_backend.GetChassisPower(ctx, job.Nodes, job.Start, job.End, opts, logger)
+	pie(logger.Error, err, fmt.Sprintf("Failed getting chassis power of job %v", job.SlurmId), http.StatusInternalServerError)
+	ret["power"] = comparedSeries{chassisPower.Time, sum_series(slices.Collect(maps.Values(chassisPower.PowerByNode)), len(chassisPower.Time))}
+	warnings = append(warnings, chassisPower.Warnings.Warnings...)
+
+	return ret, warnings
+}
+
+// places the time buckets at their offset from the first time bucket, which contains the job's start, on a relative
+// time axis of n time buckets
+func (s comparedSeries) align(step time.Duration, n int) []float64 {
+	ret := elastic.NewSeries(n)
+	for idx, t := range s.time {
+		if bucket := int(t.Sub(s.time[0]) / step); bucket < n {
+			ret[bucket] = s.data[idx]
+		}
+	}
+	return ret
+}
+
+// the summary of all nodes of the job, see jobSummary.Get
+func (h jobComparison) summary(r *http.Request, job *util.Job) summaryValues {
+	logger := logging.GetReqLogger(r)
+	summary, err := get_backend(r, h.backends).GetJobSummary(r.Context(), job.Nodes, job.Start, job.End, logger)
+	pie(logger.Error, err, fmt.Sprintf("Failed getting summary of job %v", job.SlurmId), http.StatusInternalServerError)
+	nodes, gpus := []elastic.NodeSummary{}, []elastic.GpuSummary{}
+	for _, ns := range summary.SummaryByNode {
+		nodes = append(nodes, *ns)
+		gpus = append(gpus, ns.Gpus...)
+	}
+	return summarize(nodes, gpus, get_node_memory(r, h.config))
+}
+
+// the differences of the summary values to the baseline, null if either has no data
+func summary_delta(s, base summaryValues) summaryValues {
+	delta := func(a, b nullableFloat) nullableFloat { return a - b }
+	stats_delta := func(a, b summaryStats) summaryStats { return summaryStats{delta(a.Avg, b.Avg), delta(a.Max, b.Max)} }
+	return summaryValues{
+		CpuUser:           stats_delta(s.CpuUser, base.CpuUser),
+		CpuSystem:         stats_delta(s.CpuSystem, base.CpuSystem),
+		Cpu:               stats_delta(s.Cpu, base.Cpu),
+		MinFreeMemory:     delta(s.MinFreeMemory, base.MinFreeMemory),
+		PeakMemoryUsed:    delta(s.PeakMemoryUsed, base.PeakMemoryUsed),
+		GpuUtilization:    stats_delta(s.GpuUtilization, base.GpuUtilization),
+		MaxGpuTemperature: delta(s.MaxGpuTemperature, base.MaxGpuTemperature),
+		Energy:            delta(s.Energy, base.Energy),
+		AveragePower:      delta(s.AveragePower, base.AveragePower),
+	}
+}
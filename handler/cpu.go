@@ -4,53 +4,99 @@ import (
 	"encoding/json"
 	"net/http"
 	"slices"
+	"time"
 
-	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/backend"
 	"cscs.ch/hpcdata/logging"
 	"cscs.ch/hpcdata/util"
-	"github.com/gorilla/mux"
 )
 
 type cpu struct {
 	config   *util.Config
-	esclient *elastic.Client
+	backends backend.Backends
 }
 
-func GetNodeCpuHandler(config *util.Config, esclient *elastic.Client) func(w http.ResponseWriter, r *http.Request) {
-	return wrap(cpu{config, esclient})
+func GetNodeCpuHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(cpu{config, backends})
+}
+
+func GetNodeCpuStreamHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(stream{config, backends, cpu{config, backends}, util.Aggregations})
 }
 
 func (h cpu) Get(w http.ResponseWriter, r *http.Request) {
 	logger := logging.GetReqLogger(r)
-	job, from, to := panic_if_no_access(r, h.esclient, h.config)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
 
 	logger.Debug().Msgf("Passed all security checks to fetch cpu data data for job=%+v in the time window from=%v to=%v", job, from, to)
 
-	vars := mux.Vars(r)
-	nodes := job.Nodes
-	if node_id, exists := vars["node_id"]; exists {
-		nodes = []util.Node{{Nid: node_id}}
-		// security check that the node is part of the job
-		if !slices.ContainsFunc(job.Nodes, func(n util.Node) bool { return n.Nid == node_id }) {
-			pie(logger.Warn, condition_error{"The requested node_id is not part of the job"}, "", http.StatusBadRequest)
-		}
-	}
-	cpuData, err := h.esclient.GetCpuData(nodes, from, to, logger)
+	opts := get_query_options(r, from, to, util.Aggregations)
+	ret, _ := h.series(r, job, from, to, opts)
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+func (h cpu) series(r *http.Request, job *util.Job, from, to time.Time, opts util.QueryOptions) (any, []time.Time) {
+	logger := logging.GetReqLogger(r)
+	nodes := get_nodes(r, job)
+	fill := get_fill(r)
+	cpuData, err := get_backend(r, h.backends).GetCpuData(r.Context(), nodes, from, to, opts, logger)
 	pie(logger.Error, err, "Failed getting cpu data", http.StatusBadRequest)
 
 	type Cpu struct {
-		User   []float64 `json:"user"`
-		System []float64 `json:"system"`
-		Unit   string    `json:"cpu_unit"`
+		User   nullableSeries `json:"user"`
+		System nullableSeries `json:"system"`
+		Unit   string         `json:"cpu_unit"`
 	}
+	if groups, warnings := get_groups(r, nodes); groups != nil {
+		// the mean usage of the group's nodes
+		type CpuGroup struct {
+			Cpu
+			Nodes []string `json:"nodes"`
+		}
+		ret := struct {
+			Time     []epochTime         `json:"time"`
+			Groups   map[string]CpuGroup `json:"groups"`
+			Xnames   map[string]string   `json:"xnames"`
+			Warnings []string            `json:"warnings,omitempty"`
+		}{as_epoch_array(cpuData.Time), map[string]CpuGroup{}, xnames(nodes), slices.Concat(cpuData.Warnings.Warnings, warnings)}
+		for _, g := range groups {
+			user, system := [][]float64{}, [][]float64{}
+			for _, nid := range g.nids {
+				if md, ok := cpuData.CpuByNode[nid]; ok {
+					user = append(user, md.User)
+					system = append(system, md.System)
+				}
+			}
+			ret.Groups[g.location] = CpuGroup{Cpu{User: combine_series(user, len(cpuData.Time), mean), System: combine_series(system, len(cpuData.Time), mean), Unit: "%"}, g.nids}
+		}
+		all := [][]float64{}
+		for _, group := range ret.Groups {
+			all = append(all, group.User, group.System)
+		}
+		ds := get_downsampling(r, len(cpuData.Time), all)
+		ret.Time = as_epoch_array(sample(ds, cpuData.Time))
+		for location, group := range ret.Groups {
+			group.User, group.System = fill.series(sample(ds, group.User)), fill.series(sample(ds, group.System))
+			ret.Groups[location] = group
+		}
+		return ret, cpuData.Time
+	}
+	all := [][]float64{}
+	for _, md := range cpuData.CpuByNode {
+		all = append(all, md.User, md.System)
+	}
+	ds := get_downsampling(r, len(cpuData.Time), all)
 	ret := struct {
-		Time  []epochTime    `json:"time"`
-		Nodes map[string]Cpu `json:"nodes"`
-	}{as_epoch_array(cpuData.Time), map[string]Cpu{}}
+		Time     []epochTime       `json:"time"`
+		Nodes    map[string]Cpu    `json:"nodes"`
+		Xnames   map[string]string `json:"xnames"`
+		Warnings []string          `json:"warnings,omitempty"`
+	}{as_epoch_array(sample(ds, cpuData.Time)), map[string]Cpu{}, xnames(nodes), cpuData.Warnings.Warnings}
 	for nid, md := range cpuData.CpuByNode {
-		ret.Nodes[nid] = Cpu{User: md.User, System: md.System, Unit: "%"}
+		ret.Nodes[nid] = Cpu{User: fill.series(sample(ds, md.User)), System: fill.series(sample(ds, md.System)), Unit: "%"}
 	}
-
-	write_bytes, err := json.Marshal(ret)
-	_, _ = w.Write(write_bytes)
+	return ret, cpuData.Time
 }
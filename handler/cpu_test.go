@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+// stubBackend serves a fixed job and fixed cpu data, all other queries of backend.MetricsBackend panic
+type stubBackend struct {
+	backend.MetricsBackend
+	job   util.Job
+	cpu   elastic.CpuData
+	err   error
+	nodes []util.Node // the nodes of the last GetCpuData call
+}
+
+func (b *stubBackend) GetJob(ctx context.Context, jobid string, cluster_name string, logger *zerolog.Logger) (*util.Job, error) {
+	if jobid != b.job.SlurmId {
+		return nil, fmt.Errorf("unknown job %v - %w", jobid, util.ErrInvalidInput)
+	}
+	job := b.job
+	return &job, nil
+}
+
+func (b *stubBackend) GetCpuData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.CpuData, error) {
+	b.nodes = nodes
+	if b.err != nil {
+		return nil, b.err
+	}
+	ret := elastic.CpuData{Time: b.cpu.Time, CpuByNode: map[string]*elastic.Cpu{}}
+	for _, n := range nodes {
+		if cpu, ok := b.cpu.CpuByNode[n.Nid]; ok {
+			ret.CpuByNode[n.Nid] = cpu
+		}
+	}
+	return &ret, nil
+}
+
+// nopRedisLogger silences the connection errors of the unreachable redis
+type nopRedisLogger struct{}
+
+func (nopRedisLogger) Printf(ctx context.Context, format string, v ...any) {}
+
+// serve returns a router with the cpu endpoint of the cluster `daint` and a valid Authorization header for it.
+// Firecrest reports that the caller is a member of the group `proj1` and knows no jobs, i.e. jobs are fetched from the backend.
+func serve(t *testing.T, metrics backend.MetricsBackend) (*mux.Router, string) {
+	key := []byte("secret")
+	jwks_keyfuncs = []*keyfunc.JWKS{keyfunc.NewGiven(map[string]keyfunc.GivenKey{
+		"test": keyfunc.NewGivenHMAC(key, keyfunc.GivenKeyOptions{Algorithm: jwt.SigningMethodHS256.Alg()}),
+	})}
+	t.Cleanup(func() { jwks_keyfuncs = nil })
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"scope": "openid", "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing listens on port 1, every lookup is a cache miss
+	InitRedis(util.RedisConfig{Address: "127.0.0.1:1"}, 100*time.Millisecond)
+	redis.SetLogger(nopRedisLogger{})
+
+	f7t := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status/daint/userinfo" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"user":   map[string]string{"id": "1000", "name": "user"},
+			"groups": []map[string]string{{"id": "2000", "name": "proj1"}},
+		})
+	}))
+	t.Cleanup(f7t.Close)
+
+	config := &util.Config{Clusters: []util.ClusterConfig{{Name: "daint", F7tURL: f7t.URL, ElasticName: "daint"}}}
+	config.Timeouts.Firecrest = time.Minute
+	backends := backend.Backends{"daint": metrics}
+
+	nop := zerolog.Nop()
+	middleware := logging.RequestLoggingMiddleware{Logger: &nop}
+	router := mux.NewRouter()
+	router.Use(middleware.Middleware)
+	router.HandleFunc("/metrics/{system_name}/{job_id}/node/cpu", GetNodeCpuHandler(config, backends))
+	router.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/cpu", GetNodeCpuHandler(config, backends))
+	return router, "Bearer " + signed
+}
+
+func TestCpuHandler(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	job := util.Job{
+		SlurmId:  "1",
+		Account:  "proj1",
+		Start:    start,
+		End:      start.Add(time.Minute),
+		Nodes:    []util.Node{{Nid: "nid000001"}, {Nid: "nid000002"}},
+		Finished: true,
+	}
+	cpu := elastic.CpuData{
+		Time: []time.Time{start, start.Add(30 * time.Second), start.Add(time.Minute)},
+		CpuByNode: map[string]*elastic.Cpu{
+			"nid000001": {User: []float64{10, 20, 30}, System: []float64{1, 2, 3}},
+			"nid000002": {User: []float64{40, 50, 60}, System: []float64{4, 5, 6}},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		auth       bool
+		job        util.Job
+		err        error
+		statuscode int
+		nodes      []string // the nodes queried from the backend
+	}{
+		{"job", "/metrics/daint/1/node/cpu", true, job, nil, http.StatusOK, []string{"nid000001", "nid000002"}},
+		{"node", "/metrics/daint/1/nid000002/node/cpu", true, job, nil, http.StatusOK, []string{"nid000002"}},
+		{"node outside of the job", "/metrics/daint/1/nid000003/node/cpu", true, job, nil, http.StatusBadRequest, nil},
+		{"unknown job", "/metrics/daint/2/node/cpu", true, job, nil, http.StatusBadRequest, nil},
+		{"unknown cluster", "/metrics/eiger/1/node/cpu", true, job, nil, http.StatusBadRequest, nil},
+		{"invalid points", "/metrics/daint/1/node/cpu?points=0", true, job, nil, http.StatusBadRequest, nil},
+		{"without a token", "/metrics/daint/1/node/cpu", false, job, nil, http.StatusForbidden, nil},
+		{"job of another account", "/metrics/daint/1/node/cpu", true, util.Job{SlurmId: "1", Account: "proj2", Start: start, End: start.Add(time.Minute), Nodes: job.Nodes, Finished: true}, nil, http.StatusUnauthorized, nil},
+		{"too many points for the backend", "/metrics/daint/1/node/cpu", true, job, fmt.Errorf("too many buckets - %w", util.ErrInvalidInput), http.StatusBadRequest, []string{"nid000001", "nid000002"}},
+		{"not supported by the backend", "/metrics/daint/1/node/cpu", true, job, fmt.Errorf("no cpu data - %w", util.ErrNotSupported), http.StatusNotImplemented, []string{"nid000001", "nid000002"}},
+		{"backend timeout", "/metrics/daint/1/node/cpu", true, job, context.DeadlineExceeded, http.StatusGatewayTimeout, []string{"nid000001", "nid000002"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := &stubBackend{job: tt.job, cpu: cpu, err: tt.err}
+			router, auth := serve(t, metrics)
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if tt.auth {
+				req.Header.Set("Authorization", auth)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.statuscode {
+				t.Fatalf("got status code %v, expected %v. body=%v", rec.Code, tt.statuscode, rec.Body.String())
+			}
+			queried := []string{}
+			for _, n := range metrics.nodes {
+				queried = append(queried, n.Nid)
+			}
+			if !slices.Equal(queried, tt.nodes) {
+				t.Errorf("queried the nodes %v, expected %v", queried, tt.nodes)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			ret := struct {
+				Time  []int64 `json:"time"`
+				Nodes map[string]struct {
+					User   []float64 `json:"user"`
+					System []float64 `json:"system"`
+				} `json:"nodes"`
+			}{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &ret); err != nil {
+				t.Fatal(err)
+			}
+			if len(ret.Time) != len(cpu.Time) || ret.Time[0] != start.Unix() {
+				t.Errorf("got the timeline %v, expected %v", ret.Time, cpu.Time)
+			}
+			if len(ret.Nodes) != len(tt.nodes) {
+				t.Errorf("got the nodes %v, expected %v", ret.Nodes, tt.nodes)
+			}
+			for _, nid := range tt.nodes {
+				if !slices.Equal(ret.Nodes[nid].User, cpu.CpuByNode[nid].User) || !slices.Equal(ret.Nodes[nid].System, cpu.CpuByNode[nid].System) {
+					t.Errorf("got %v for %v, expected %v", ret.Nodes[nid], nid, *cpu.CpuByNode[nid])
+				}
+			}
+		})
+	}
+}
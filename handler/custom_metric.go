@@ -5,25 +5,24 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"slices"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 
-	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/backend"
 	"cscs.ch/hpcdata/logging"
 	"cscs.ch/hpcdata/util"
 )
 
 type customMetric struct {
 	config *util.Config
-	esclient *elastic.Client
+	backends backend.Backends
 	db     *util.DB
 }
 
-func GetCustomMetricHandler(config *util.Config, esclient *elastic.Client, db *util.DB) func(w http.ResponseWriter, r *http.Request) {
-	return wrap(customMetric{config, esclient, db})
+func GetCustomMetricHandler(config *util.Config, backends backend.Backends, db *util.DB) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(customMetric{config, backends, db})
 }
 
 type customMetricOutput struct {
@@ -36,21 +35,14 @@ type customMetricOutputReturn map[string][]customMetricOutput
 
 func (h customMetric) Get(w http.ResponseWriter, r *http.Request) {
 	logger := logging.GetReqLogger(r)
-	job, from, to := panic_if_no_access(r, h.esclient, h.config)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
 
 
 	logger.Debug().Msgf("Passed all security checks to fetch GPU temperature data for job=%+v in the time window from=%v to=%v", job, from, to)
 
 	vars := mux.Vars(r)
 	cluster := vars["system_name"]
-	nodes := job.Nodes
-	if node_id, exists := vars["node_id"]; exists {
-		nodes = []util.Node{{Nid: node_id}}
-		// security check that the node is part of the job
-		if !slices.ContainsFunc(nodes, func(n util.Node) bool { return n.Nid == node_id }) {
-			pie(logger.Warn, condition_error{"The requested node_id is not part of the job"}, "", http.StatusBadRequest)
-		}
-	}
+	nodes := get_nodes(r, job)
 
 	metricName := r.URL.Query().Get("name")
 	if metricName == "" {
@@ -65,7 +57,7 @@ func (h customMetric) Get(w http.ResponseWriter, r *http.Request) {
 
 	ret := customMetricOutputReturn{}
 	for _, nid := range nodes {
-		if timestamps, values, err := h.db.GetMetricData(fmt.Sprintf("%v", job.SlurmId), metricName, metricContext, nid.Nid, cluster); err != nil {
+		if timestamps, values, err := h.db.GetMetricData(r.Context(), fmt.Sprintf("%v", job.SlurmId), metricName, metricContext, nid.Nid, cluster); err != nil {
 			logger.Error().Msgf("Failed getting custom userdata from database")
 			pie(logger.Warn, err, "Failed getting custom data from database", http.StatusBadRequest)
 		} else {
@@ -141,14 +133,21 @@ func (h customMetric) Post(w http.ResponseWriter, r *http.Request) {
 	if inData.Context == "" {
 		pie(logger.Warn, condition_error{"Field `context` is missing"}, "", http.StatusBadRequest)
 	}
+	cluster_config, err := h.config.GetClusterConfig(vars["system_name"])
+	pie(logger.Warn, err, "`system_name` must be a valid system", http.StatusBadRequest)
+	// the node can be given as nid or xname, a missing xname is taken from the node inventory
+	node_id := cluster_config.Inventory.Nid(vars["node_id"])
+	if inData.Xname == "" {
+		inData.Xname = cluster_config.Inventory.Xname(node_id)
+	}
 	if inData.Xname == "" {
-		pie(logger.Warn, condition_error{"Field `xname` is missing"}, "", http.StatusBadRequest)
+		pie(logger.Warn, condition_error{"Field `xname` is missing and the node is not in the node inventory"}, "", http.StatusBadRequest)
 	}
 	if inData.Timestamp == 0 {
 		inData.Timestamp = time.Now().Unix()
 	}
 
-	if !h.db.PushMetricData(inData.Timestamp, vars["job_id"], inData.MetricName, inData.MetricValue, inData.Xname, vars["node_id"], inData.Context, vars["system_name"]) {
+	if !h.db.PushMetricData(r.Context(), inData.Timestamp, vars["job_id"], inData.MetricName, inData.MetricValue, inData.Xname, node_id, inData.Context, vars["system_name"]) {
 		logger.Error().Msgf("Failed pushing custom userdata to database")
 		w.Write([]byte("Failed pushing custom userdata to database"))
 	} else {
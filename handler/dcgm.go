@@ -5,17 +5,19 @@ import (
 	"fmt"
 	"net/http"
 	"slices"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
-	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/backend"
 	"cscs.ch/hpcdata/logging"
 	"cscs.ch/hpcdata/util"
 )
 
 type dcgm struct {
 	config   *util.Config
-	esclient *elastic.Client
+	backends backend.Backends
 	metric   string
 }
 
@@ -24,41 +26,143 @@ type dcgmReturn struct {
 	Unit string
 }
 
+// allowlist of the DCGM metrics that can be queried, key==metric name at /gpu/{dcgm_metric}
 var dcgmMetricUnit = map[string]dcgmReturn{
 	"gpu_temp":        {"temperature", "°C"},
 	"gpu_utilization": {"utilization", "%"},
+	"fb_used":         {"framebuffer memory used", "MiB"},
+	"sm_clock":        {"SM clock", "MHz"},
+	"power_usage":     {"power usage", "Watt"},
+	"tensor_active":   {"tensor pipe activity", "ratio of cycles"},
+	"pcie_tx_bytes":   {"PCIe transmitted", "bytes/s"},
+	"pcie_rx_bytes":   {"PCIe received", "bytes/s"},
+	"nvlink_tx_bytes": {"NVLink transmitted", "bytes/s"},
+	"nvlink_rx_bytes": {"NVLink received", "bytes/s"},
+	"xid_errors":      {"last XID error", "XID"},
 }
 
-func GetDcgmData(config *util.Config, esclient *elastic.Client, metric string) func(w http.ResponseWriter, r *http.Request) {
-	return wrap(dcgm{config, esclient, metric})
+// the aggregation within a time bucket of the metrics, for which only one is meaningful, the `agg` query is ignored
+var dcgmMetricAggregation = map[string]string{
+	"xid_errors": "last", // error codes cannot be averaged
+}
+
+// if metric is empty, the metric is taken from the path variable `dcgm_metric`
+func GetDcgmData(config *util.Config, backends backend.Backends, metric string) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(dcgm{config, backends, metric})
+}
+
+// if metric is empty, the metric is taken from the path variable `dcgm_metric`
+func GetDcgmStreamHandler(config *util.Config, backends backend.Backends, metric string) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(stream{config, backends, dcgm{config, backends, metric}, util.Aggregations})
+}
+
+// takes the metric from the path variable `dcgm_metric` if none is set, panics if it is unknown
+func (h dcgm) with_metric(r *http.Request) dcgm {
+	if h.metric == "" {
+		h.metric = mux.Vars(r)["dcgm_metric"]
+		if _, ok := dcgmMetricUnit[h.metric]; !ok {
+			pie(logging.GetReqLogger(r).Warn, herr("The requested DCGM metric is not available. See the `gpu` endpoint for the list of available metrics", fmt.Sprintf("dcgm_metric=%v", h.metric)), "", http.StatusNotFound)
+		}
+	}
+	return h
 }
 
 func (h dcgm) Get(w http.ResponseWriter, r *http.Request) {
 	logger := logging.GetReqLogger(r)
-	job, from, to := panic_if_no_access(r, h.esclient, h.config)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
+	h = h.with_metric(r)
 
-	logger.Debug().Msgf("Passed all security checks to fetch dcgm data data for job=%+v in the time window from=%v to=%v", job, from, to)
+	logger.Debug().Msgf("Passed all security checks to fetch dcgm data %v for job=%+v in the time window from=%v to=%v", h.metric, job, from, to)
 
-	vars := mux.Vars(r)
-	nodes := job.Nodes
-	if node_id, exists := vars["node_id"]; exists {
-		nodes = []util.Node{{Nid: node_id}}
-		// security check that the node is part of the job
-		if !slices.ContainsFunc(job.Nodes, func(n util.Node) bool { return n.Nid == node_id }) {
-			pie(logger.Warn, condition_error{"The requested node_id is not part of the job"}, "", http.StatusBadRequest)
-		}
+	opts := get_query_options(r, from, to, util.Aggregations)
+	ret, _ := h.series(r, job, from, to, opts)
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+func (h dcgm) series(r *http.Request, job *util.Job, from, to time.Time, opts util.QueryOptions) (any, []time.Time) {
+	logger := logging.GetReqLogger(r)
+	h = h.with_metric(r)
+	if agg, ok := dcgmMetricAggregation[h.metric]; ok {
+		opts.Agg = agg
 	}
-	dcgmData, err := h.esclient.GetDcgmData(nodes, from, to, h.metric, logger)
+	nodes := get_nodes(r, job)
+	fill := get_fill(r)
+	dcgmData, err := get_backend(r, h.backends).GetDcgmData(r.Context(), nodes, from, to, h.metric, opts, logger)
 	pie(logger.Error, err, "Failed getting DCGM data", http.StatusBadRequest)
 
+	all := [][]float64{}
+	for _, gpus := range dcgmData.MetricByNode {
+		for _, gpu := range gpus {
+			all = append(all, gpu.Data)
+		}
+	}
+	ds := get_downsampling(r, len(dcgmData.Time), all)
 	ret := struct {
-		Time  []epochTime               `json:"time"`
-		Nodes map[string]map[string]any `json:"nodes"`
-	}{as_epoch_array(dcgmData.Time), map[string]map[string]any{}}
+		Time     []epochTime               `json:"time"`
+		Nodes    map[string]map[string]any `json:"nodes"`
+		Xnames   map[string]string         `json:"xnames"`
+		Warnings []string                  `json:"warnings,omitempty"`
+	}{as_epoch_array(sample(ds, dcgmData.Time)), map[string]map[string]any{}, xnames(nodes), dcgmData.Warnings.Warnings}
+	type DcgmData struct {
+		GpuIndex int
+		Data     nullableSeries
+	}
 	for nid, dcgmMetric := range dcgmData.MetricByNode {
-		ret.Nodes[nid] = map[string]any{h.metric: dcgmMetric, fmt.Sprintf("%v_unit", h.metric): dcgmMetricUnit[h.metric]}
+		gpus := []DcgmData{}
+		for _, gpu := range dcgmMetric {
+			gpus = append(gpus, DcgmData{gpu.GpuIndex, fill.series(sample(ds, gpu.Data))})
+		}
+		ret.Nodes[nid] = map[string]any{h.metric: gpus, fmt.Sprintf("%v_unit", h.metric): dcgmMetricUnit[h.metric]}
+	}
+	return ret, dcgmData.Time
+}
+
+type dcgmMetrics struct {
+	config   *util.Config
+	backends backend.Backends
+}
+
+func GetDcgmMetricsHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(dcgmMetrics{config, backends})
+}
+
+/*
+Returns the DCGM metrics with data for the job's nodes, which can be queried at /gpu/{metric}
+
+	{
+		"metrics": [{"metric": string, "name": string, "unit": string}],
+	}
+*/
+func (h dcgmMetrics) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
+
+	logger.Debug().Msgf("Passed all security checks to fetch available dcgm metrics for job=%+v in the time window from=%v to=%v", job, from, to)
+
+	nodes := get_nodes(r, job)
+	available, err := get_backend(r, h.backends).GetDcgmMetricNames(r.Context(), nodes, from, to, logger)
+	pie(logger.Error, err, "Failed getting available DCGM metrics", http.StatusInternalServerError)
+
+	type Metric struct {
+		Metric string `json:"metric"`
+		Name   string `json:"name"`
+		Unit   string `json:"unit"`
+	}
+	ret := struct {
+		Metrics []Metric `json:"metrics"`
+	}{[]Metric{}}
+	for _, metric := range available {
+		// only report metrics that can be queried
+		if info, ok := dcgmMetricUnit[metric]; ok {
+			ret.Metrics = append(ret.Metrics, Metric{metric, info.Name, info.Unit})
+		}
 	}
+	slices.SortFunc(ret.Metrics, func(a, b Metric) int { return strings.Compare(a.Metric, b.Metric) })
 
 	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
 	_, _ = w.Write(write_bytes)
 }
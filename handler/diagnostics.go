@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/diagnostics"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+type jobDiagnostics struct {
+	config   *util.Config
+	backends backend.Backends
+}
+
+func GetDiagnosticsHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(jobDiagnostics{config, backends})
+}
+
+/*
+	Returns the findings of the diagnostics rules (see `diagnostics` in the config), the most severe first
+
+	{
+		"findings": [{
+			"rule": string,
+			"severity": "info" | "warning" | "critical",
+			"message": string,
+			"node": string <omitted if the finding concerns the whole job>,
+			"xname": string <omitted if the finding concerns the whole job or the xname is unknown>,
+			"gpu": int <omitted if the finding does not concern a single GPU>,
+			"fraction": float <fraction of the runtime during which the condition holds>,
+			"windows": [{"from": int <epoch-time>, "to": int <epoch-time>}],
+		}],
+	}
+*/
+func (h jobDiagnostics) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
+
+	logger.Debug().Msgf("Passed all security checks to evaluate the diagnostics for job=%+v in the time window from=%v to=%v", job, from, to)
+
+	cluster_config, err := h.config.GetClusterConfig(mux.Vars(r)["system_name"])
+	pie(logger.Error, err, "", http.StatusInternalServerError)
+	result, err := diagnostics.Evaluate(r.Context(), get_backend(r, h.backends), h.config.Diagnostics, cluster_config, job.Nodes, from, to, logger)
+	pie(logger.Error, err, "Failed evaluating the diagnostics", http.StatusInternalServerError)
+
+	type Window struct {
+		From epochTime `json:"from"`
+		To   epochTime `json:"to"`
+	}
+	type Finding struct {
+		Rule     string   `json:"rule"`
+		Severity string   `json:"severity"`
+		Message  string   `json:"message"`
+		Node     string   `json:"node,omitempty"`
+		Xname    string   `json:"xname,omitempty"`
+		Gpu      *int     `json:"gpu,omitempty"`
+		Fraction float64  `json:"fraction"`
+		Windows  []Window `json:"windows"`
+	}
+	ret := struct {
+		Findings []Finding `json:"findings"`
+		Warnings []string  `json:"warnings,omitempty"`
+	}{[]Finding{}, result.Warnings}
+	node_xnames := xnames(job.Nodes)
+	for _, f := range result.Findings {
+		finding := Finding{Rule: f.Rule, Severity: f.Severity, Message: f.Message, Node: f.Node, Xname: node_xnames[f.Node], Gpu: f.Gpu, Fraction: f.Fraction, Windows: []Window{}}
+		for _, window := range f.Windows {
+			finding.Windows = append(finding.Windows, Window{epochTime{window.From}, epochTime{window.To}})
+		}
+		ret.Findings = append(ret.Findings, finding)
+	}
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"cscs.ch/hpcdata/logging"
+)
+
+// the smallest `max_points` query, the first and the last time bucket are always kept
+const min_max_points = 3
+
+// the indices of the time buckets of a response that are kept, nil keeps all time buckets
+type downsampling []int
+
+// parses the `max_points` query and selects at most max_points of the n time buckets of the series, see lttb.
+// All series of a response share their time buckets, i.e. pass all of them.
+func get_downsampling(r *http.Request, n int, series [][]float64) downsampling {
+	query := r.URL.Query().Get("max_points")
+	if query == "" {
+		return nil
+	}
+	points, err := strconv.Atoi(query)
+	if err != nil || points < min_max_points {
+		pie(logging.GetReqLogger(r).Warn, herr(fmt.Sprintf("Failed parsing `max_points` query. It must be an integer of at least %v", min_max_points), fmt.Sprintf("max_points=%v", query)), "", http.StatusBadRequest)
+	}
+	return lttb(n, points, series)
+}
+
+// the values of the kept time buckets
+func sample[T any](d downsampling, data []T) []T {
+	if d == nil {
+		return data
+	}
+	ret := make([]T, len(d))
+	for idx, bucket := range d {
+		ret[idx] = data[bucket]
+	}
+	return ret
+}
+
+// Largest-Triangle-Three-Buckets (Steinarsson, 2013) for several series that share their time buckets.
+// The n time buckets are divided into m-2 ranges, from each range the time bucket is kept that forms the largest
+// triangle with the previously kept one and the average of the next range, summed over all series. The series are
+// normalized by their value range, so that every series contributes equally. Unlike averaging, this keeps the peaks.
+// Time buckets without data (NaN) do not contribute. Returns nil if n <= m.
+func lttb(n, m int, series [][]float64) downsampling {
+	if n <= m {
+		return nil
+	}
+	scale := make([]float64, len(series))
+	for s, data := range series {
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, v := range data {
+			if !math.IsNaN(v) {
+				low, high = min(low, v), max(high, v)
+			}
+		}
+		if high > low && len(data) == n {
+			scale[s] = 1 / (high - low)
+		}
+	}
+
+	// the first index of the i-th range, the first and the last time bucket are ranges of their own
+	range_start := func(i int) int {
+		return min(n, 1+(i-1)*(n-2)/(m-2))
+	}
+	ret := make(downsampling, 0, m)
+	ret = append(ret, 0)
+	a := 0
+	for i := 1; i < m-1; i++ {
+		start, end, next_end := range_start(i), range_start(i+1), range_start(i+2)
+		avg_x := float64(end+next_end-1) / 2
+		avg_y := make([]float64, len(series))
+		for s, data := range series {
+			avg_y[s] = math.NaN()
+			if scale[s] == 0 {
+				continue
+			}
+			total, count := 0.0, 0
+			for _, v := range data[end:next_end] {
+				if !math.IsNaN(v) {
+					total += v
+					count++
+				}
+			}
+			if count > 0 {
+				avg_y[s] = total / float64(count)
+			}
+		}
+
+		best, best_area := start, -1.0
+		for b := start; b < end; b++ {
+			area := 0.0
+			for s, data := range series {
+				if scale[s] == 0 {
+					continue
+				}
+				// NaN if any of the three points has no data
+				v := math.Abs((float64(a)-avg_x)*(data[b]-data[a])-(float64(a)-float64(b))*(avg_y[s]-data[a])) * scale[s]
+				if !math.IsNaN(v) {
+					area += v
+				}
+			}
+			if area > best_area {
+				best, best_area = b, area
+			}
+		}
+		ret = append(ret, best)
+		a = best
+	}
+	return append(ret, n-1)
+}
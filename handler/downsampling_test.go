@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestLttb(t *testing.T) {
+	nan := math.NaN()
+	ramp := func(n int) []float64 {
+		ret := make([]float64, n)
+		for idx := range ret {
+			ret[idx] = float64(idx)
+		}
+		return ret
+	}
+	// a flat series with a single peak at `at`
+	peak := func(n, at int) []float64 {
+		ret := make([]float64, n)
+		ret[at] = 100
+		return ret
+	}
+
+	tests := []struct {
+		name     string
+		n, m     int
+		series   [][]float64
+		expected downsampling // nil checks only the invariants
+	}{
+		{"fewer buckets than points", 5, 10, [][]float64{ramp(5)}, nil},
+		{"as many buckets as points", 5, 5, [][]float64{ramp(5)}, nil},
+		{"only the endpoints and one bucket", 10, 3, [][]float64{peak(10, 4)}, downsampling{0, 4, 9}},
+		{"peak is kept", 100, 10, [][]float64{peak(100, 57)}, nil},
+		{"peak in the last range", 100, 10, [][]float64{peak(100, 88)}, nil},
+		{"peak next to the first bucket", 100, 10, [][]float64{peak(100, 1)}, nil},
+		{"peak next to the last bucket", 100, 10, [][]float64{peak(100, 98)}, nil},
+		{"peak in the first bucket", 100, 10, [][]float64{peak(100, 0)}, nil},
+		{"peak in the last bucket", 100, 10, [][]float64{peak(100, 99)}, nil},
+		{"peak of the second series is kept", 100, 10, [][]float64{ramp(100), peak(100, 33)}, nil},
+		{"constant series", 20, 5, [][]float64{slices.Repeat([]float64{1}, 20)}, nil},
+		{"no data", 20, 5, [][]float64{slices.Repeat([]float64{nan}, 20)}, nil},
+		{"no series", 20, 5, [][]float64{}, nil},
+		{"one bucket more than points", 11, 10, [][]float64{ramp(11)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lttb(tt.n, tt.m, tt.series)
+			if tt.n <= tt.m {
+				if got != nil {
+					t.Fatalf("got %v, expected all time buckets (nil)", got)
+				}
+				return
+			}
+			if len(got) != tt.m {
+				t.Fatalf("got %v time buckets, expected %v: %v", len(got), tt.m, got)
+			}
+			if got[0] != 0 || got[len(got)-1] != tt.n-1 {
+				t.Errorf("the first and the last time bucket must be kept: %v", got)
+			}
+			for idx := 1; idx < len(got); idx++ {
+				if got[idx] <= got[idx-1] {
+					t.Fatalf("the time buckets are not strictly increasing: %v", got)
+				}
+			}
+			if tt.expected != nil && !slices.Equal(got, tt.expected) {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+			for _, s := range tt.series {
+				if top := slices.Index(s, 100); top >= 0 && !slices.Contains(got, top) {
+					t.Errorf("the peak at %v is not kept: %v", top, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSample(t *testing.T) {
+	data := []string{"a", "b", "c", "d"}
+	tests := []struct {
+		name     string
+		d        downsampling
+		expected []string
+	}{
+		{"all", nil, data},
+		{"endpoints", downsampling{0, 3}, []string{"a", "d"}},
+		{"some", downsampling{0, 2, 3}, []string{"a", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sample(tt.d, data); !slices.Equal(got, tt.expected) {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -2,53 +2,84 @@ package handler
 
 import (
 	"encoding/json"
+	"maps"
 	"net/http"
 	"slices"
 
-	"github.com/gorilla/mux"
-
-	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/backend"
 	"cscs.ch/hpcdata/logging"
 	"cscs.ch/hpcdata/util"
 )
 
 type chassisEnergy struct {
 	config   *util.Config
-	esclient *elastic.Client
+	backends backend.Backends
 }
 
-func GetChassisEnergyHandler(config *util.Config, esclient *elastic.Client) func(w http.ResponseWriter, r *http.Request) {
-	return wrap(chassisEnergy{config, esclient})
+func GetChassisEnergyHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(chassisEnergy{config, backends})
 }
 
 func (h chassisEnergy) Get(w http.ResponseWriter, r *http.Request) {
 	logger := logging.GetReqLogger(r)
-	job, from, to := panic_if_no_access(r, h.esclient, h.config)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
 
 	logger.Debug().Msgf("Passed all security checks to fetch chassis energy data for job=%+v in the time window from=%v to=%v", job, from, to)
 
-	vars := mux.Vars(r)
-	nodes := job.Nodes
-	if node_id, exists := vars["node_id"]; exists {
-		nodes = []util.Node{{Nid: node_id}}
-		// security check that the node is part of the job
-		if !slices.ContainsFunc(job.Nodes, func(n util.Node) bool { return n.Nid == node_id }) {
-			pie(logger.Warn, condition_error{"The requested node_id is not part of the job"}, "", http.StatusBadRequest)
-		}
-	}
-	chassisEnergy, err := h.esclient.GetChassisEnergy(nodes, from, to, logger)
+	nodes := get_nodes(r, job)
+	fill := get_fill(r)
+	// the energy is a counter, it cannot be aggregated differently within a time bucket
+	opts := get_query_options(r, from, to, nil)
+	chassisEnergy, err := get_backend(r, h.backends).GetChassisEnergy(r.Context(), nodes, from, to, opts, logger)
 	pie(logger.Error, err, "Failed getting chassis energy", http.StatusInternalServerError)
 
 	type ChassisEnergy struct {
-		Energy []float64 `json:"energy"`
-		Unit   string    `json:"energy_unit"`
+		Energy nullableSeries `json:"energy"`
+		Unit   string         `json:"energy_unit"`
+	}
+	if groups, warnings := get_groups(r, nodes); groups != nil {
+		// the total energy of the group's nodes
+		type ChassisEnergyGroup struct {
+			ChassisEnergy
+			Nodes []string `json:"nodes"`
+		}
+		ret := struct {
+			Time     []epochTime                   `json:"time"`
+			Groups   map[string]ChassisEnergyGroup `json:"groups"`
+			Xnames   map[string]string             `json:"xnames"`
+			Warnings []string                      `json:"warnings,omitempty"`
+		}{as_epoch_array(chassisEnergy.Time), map[string]ChassisEnergyGroup{}, xnames(nodes), slices.Concat(chassisEnergy.Warnings.Warnings, warnings)}
+		for _, g := range groups {
+			energy := [][]float64{}
+			for _, nid := range g.nids {
+				energy = append(energy, chassisEnergy.EnergyByNode[nid])
+			}
+			ret.Groups[g.location] = ChassisEnergyGroup{ChassisEnergy{Energy: sum_series(energy, len(chassisEnergy.Time)), Unit: "Joule"}, g.nids}
+		}
+		all := [][]float64{}
+		for _, group := range ret.Groups {
+			all = append(all, group.Energy)
+		}
+		ds := get_downsampling(r, len(chassisEnergy.Time), all)
+		ret.Time = as_epoch_array(sample(ds, chassisEnergy.Time))
+		for location, group := range ret.Groups {
+			group.Energy = fill.series(sample(ds, group.Energy))
+			ret.Groups[location] = group
+		}
+		write_bytes, err := json.Marshal(ret)
+		pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+		_, _ = w.Write(write_bytes)
+		return
 	}
+	ds := get_downsampling(r, len(chassisEnergy.Time), slices.Collect(maps.Values(chassisEnergy.EnergyByNode)))
 	ret := struct {
-		Time  []epochTime              `json:"time"`
-		Nodes map[string]ChassisEnergy `json:"nodes"`
-	}{as_epoch_array(chassisEnergy.Time), map[string]ChassisEnergy{}}
+		Time     []epochTime              `json:"time"`
+		Nodes    map[string]ChassisEnergy `json:"nodes"`
+		Xnames   map[string]string        `json:"xnames"`
+		Warnings []string                 `json:"warnings,omitempty"`
+	}{as_epoch_array(sample(ds, chassisEnergy.Time)), map[string]ChassisEnergy{}, xnames(nodes), chassisEnergy.Warnings.Warnings}
 	for nid, energy := range chassisEnergy.EnergyByNode {
-		ret.Nodes[nid] = ChassisEnergy{Energy: energy, Unit: "Joule"}
+		ret.Nodes[nid] = ChassisEnergy{Energy: fill.series(sample(ds, energy)), Unit: "Joule"}
 	}
 
 	write_bytes, err := json.Marshal(ret)
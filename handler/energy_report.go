@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+const joule_per_kwh = 3.6e6
+
+type energyReport struct {
+	config   *util.Config
+	backends backend.Backends
+}
+
+func GetEnergyReportHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(energyReport{config, backends})
+}
+
+type energyReportValues struct {
+	Energy map[string]nullableFloat `json:"energy"`
+	Co2    nullableFloat            `json:"co2"`
+}
+
+/*
+	Returns the energy consumed by the job, per node and in total
+
+	{
+		"nodes": {
+			"<node_id>": {"energy": {"node": float, "cpu": float, "gpu": float, "memory": float}, "co2": float},
+		},
+		"xnames": {"<node_id>": string <omitted if the xname is unknown>},
+		"total": {"energy": {"node": float, "cpu": float, "gpu": float, "memory": float}, "co2": float},
+		"carbon_intensity": float <average over the job>,
+		"units": {"energy": "kWh", "co2": "gCO2eq", "carbon_intensity": "gCO2eq/kWh"},
+	}
+
+	`node` is the energy of the whole node, the other components are part of it.
+	The CO2-equivalent uses the carbon intensity profile of the cluster (carbon_intensity_file in the config), it is null
+	if the cluster has no profile. A component without any data is null.
+*/
+func (h energyReport) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
+
+	logger.Debug().Msgf("Passed all security checks to fetch the energy report for job=%+v in the time window from=%v to=%v", job, from, to)
+
+	vars := mux.Vars(r)
+	nodes := get_nodes(r, job)
+	cluster_config, err := h.config.GetClusterConfig(vars["system_name"])
+	pie(logger.Error, err, "", http.StatusInternalServerError)
+	breakdown, err := get_backend(r, h.backends).GetEnergyBreakdown(r.Context(), nodes, from, to, logger)
+	pie(logger.Error, err, "Failed getting energy breakdown", http.StatusInternalServerError)
+
+	ret := struct {
+		Nodes           map[string]energyReportValues `json:"nodes"`
+		Xnames          map[string]string             `json:"xnames"`
+		Total           energyReportValues            `json:"total"`
+		CarbonIntensity nullableFloat                 `json:"carbon_intensity"`
+		Units           map[string]string             `json:"units"`
+		Warnings        []string                      `json:"warnings,omitempty"`
+	}{
+		Nodes:    map[string]energyReportValues{},
+		Xnames:   xnames(nodes),
+		Units:    map[string]string{"energy": "kWh", "co2": "gCO2eq", "carbon_intensity": "gCO2eq/kWh"},
+		Warnings: breakdown.Warnings.Warnings,
+	}
+
+	totalEnergy := map[string]float64{}
+	totalCo2 := math.NaN()
+	for _, component := range elastic.EnergyComponents {
+		totalEnergy[component] = math.NaN()
+	}
+	for nid, components := range breakdown.EnergyByNode {
+		node := energyReportValues{Energy: map[string]nullableFloat{}, Co2: nullableFloat(math.NaN())}
+		for _, component := range elastic.EnergyComponents {
+			kwh := math.NaN()
+			for _, joule := range components[component] {
+				if !math.IsNaN(joule) {
+					kwh = elastic.SumIgnoringNaN(kwh, joule/joule_per_kwh)
+				}
+			}
+			node.Energy[component] = nullableFloat(kwh)
+			if !math.IsNaN(kwh) {
+				totalEnergy[component] = elastic.SumIgnoringNaN(totalEnergy[component], kwh)
+			}
+		}
+		if len(cluster_config.CarbonProfile) > 0 {
+			co2 := math.NaN()
+			for idx, joule := range components["node"] {
+				if !math.IsNaN(joule) {
+					co2 = elastic.SumIgnoringNaN(co2, joule/joule_per_kwh*cluster_config.CarbonProfile.At(breakdown.Time[idx]))
+				}
+			}
+			node.Co2 = nullableFloat(co2)
+			if !math.IsNaN(co2) {
+				totalCo2 = elastic.SumIgnoringNaN(totalCo2, co2)
+			}
+		}
+		ret.Nodes[nid] = node
+	}
+	ret.Total = energyReportValues{Energy: map[string]nullableFloat{}, Co2: nullableFloat(totalCo2)}
+	for component, kwh := range totalEnergy {
+		ret.Total.Energy[component] = nullableFloat(kwh)
+	}
+	ret.CarbonIntensity = nullableFloat(totalCo2 / totalEnergy["node"])
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
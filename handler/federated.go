@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+// the maximum number of (cluster, jobid) pairs of a federated metrics request
+const max_federated_jobs = 20
+
+// the response of a request that is forwarded to the router, see forward
+type forwardedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (f *forwardedResponse) Header() http.Header {
+	return f.header
+}
+func (f *forwardedResponse) Write(b []byte) (int, error) {
+	return f.body.Write(b)
+}
+func (f *forwardedResponse) WriteHeader(status int) {
+	f.status = status
+}
+
+// the error message of a failed response, which is written by logging.RequestLoggingMiddleware
+func (f *forwardedResponse) error_message() string {
+	msg := struct {
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(f.body.Bytes(), &msg); err != nil || msg.Message == "" {
+		return strings.TrimSpace(f.body.String())
+	}
+	return msg.Message
+}
+
+// forwards a GET request for path with the query to the router, with the headers (i.e. the JWT) of r
+func forward(router http.Handler, r *http.Request, path string, query url.Values) *forwardedResponse {
+	sub := r.Clone(r.Context())
+	sub.URL = &url.URL{Path: path, RawQuery: query.Encode()}
+	sub.RequestURI = sub.URL.RequestURI()
+	sub.Method = http.MethodGet
+	sub.Body = http.NoBody
+	sub.ContentLength = 0
+	ret := forwardedResponse{header: http.Header{}, status: http.StatusOK}
+	router.ServeHTTP(&ret, sub)
+	return &ret
+}
+
+// runs forward for every path in parallel
+func forward_all(router http.Handler, r *http.Request, paths []string, query url.Values) []*forwardedResponse {
+	ret := make([]*forwardedResponse, len(paths))
+	var wg sync.WaitGroup
+	for idx, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ret[idx] = forward(router, r, path, query)
+		}()
+	}
+	wg.Wait()
+	return ret
+}
+
+// the clusters of the `cluster` queries, all configured clusters if there is none
+func get_federated_clusters(r *http.Request, config *util.Config) []string {
+	ret := r.URL.Query()["cluster"]
+	if len(ret) == 0 {
+		for _, cc := range config.Clusters {
+			ret = append(ret, cc.Name)
+		}
+	}
+	for _, cluster := range ret {
+		if _, err := config.GetClusterConfig(cluster); err != nil {
+			pie(logging.GetReqLogger(r).Warn, herr(fmt.Sprintf("The cluster %v is unknown", cluster), err.Error()), "", http.StatusBadRequest)
+		}
+	}
+	return ret
+}
+
+type federatedJobs struct {
+	config *util.Config
+	router http.Handler
+}
+
+func GetFederatedJobsHandler(config *util.Config, router http.Handler) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(federatedJobs{config, router})
+}
+
+/*
+	Returns the caller's jobs of several clusters (see /jobs/{system_name}), the most recently started first
+
+	{
+		"jobs": [{"cluster": string, <the fields of /jobs/{system_name}>}],
+		"total": int <number of jobs over all pages and clusters>,
+		"clusters": {"<cluster>": {"status": int, "total": int, "error": string <omitted on success>}},
+	}
+
+	Queries:
+		cluster: may be repeated, the default is all clusters
+		all queries of /jobs/{system_name}, `page` and `per_page` apply to each cluster
+*/
+func (h federatedJobs) Get(w http.ResponseWriter, r *http.Request) {
+	_, err := validate_jwt(r)
+	pie(logging.GetReqLogger(r).Warn, err, "JWT is invalid", http.StatusForbidden)
+
+	clusters := get_federated_clusters(r, h.config)
+	query := r.URL.Query()
+	query.Del("cluster")
+	paths := []string{}
+	for _, cluster := range clusters {
+		paths = append(paths, fmt.Sprintf("/jobs/%v", cluster))
+	}
+	responses := forward_all(h.router, r, paths, query)
+
+	type ClusterStatus struct {
+		Status int    `json:"status"`
+		Total  int    `json:"total"`
+		Error  string `json:"error,omitempty"`
+	}
+	ret := struct {
+		Jobs     []map[string]any         `json:"jobs"`
+		Total    int                      `json:"total"`
+		Clusters map[string]ClusterStatus `json:"clusters"`
+		Warnings []string                 `json:"warnings,omitempty"`
+	}{Jobs: []map[string]any{}, Clusters: map[string]ClusterStatus{}}
+	for idx, resp := range responses {
+		cluster := clusters[idx]
+		if resp.status != http.StatusOK {
+			ret.Clusters[cluster] = ClusterStatus{Status: resp.status, Error: resp.error_message()}
+			continue
+		}
+		list := struct {
+			Jobs     []map[string]any `json:"jobs"`
+			Total    int              `json:"total"`
+			Warnings []string         `json:"warnings"`
+		}{}
+		if err := json.Unmarshal(resp.body.Bytes(), &list); err != nil {
+			pie(logging.GetReqLogger(r).Error, err, fmt.Sprintf("Failed decoding the jobs of cluster %v", cluster), http.StatusInternalServerError)
+		}
+		for _, job := range list.Jobs {
+			job["cluster"] = cluster
+			ret.Jobs = append(ret.Jobs, job)
+		}
+		for _, warning := range list.Warnings {
+			ret.Warnings = append(ret.Warnings, fmt.Sprintf("%v: %v", cluster, warning))
+		}
+		ret.Total += list.Total
+		ret.Clusters[cluster] = ClusterStatus{Status: resp.status, Total: list.Total}
+	}
+	slices.SortStableFunc(ret.Jobs, func(a, b map[string]any) int {
+		start_a, _ := a["start"].(float64)
+		start_b, _ := b["start"].(float64)
+		return cmp.Compare(start_b, start_a)
+	})
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logging.GetReqLogger(r).Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+type federatedMetrics struct {
+	config *util.Config
+	router http.Handler
+}
+
+func GetFederatedMetricsHandler(config *util.Config, router http.Handler) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(federatedMetrics{config, router})
+}
+
+/*
+	Returns a metric of several jobs, which can run on different clusters, i.e. /federated/metrics/node/cpu?job=a:1&job=b:2
+	returns /metrics/a/1/node/cpu and /metrics/b/2/node/cpu
+
+	{
+		"results": [{
+			"cluster": string,
+			"jobid": string,
+			"status": int,
+			"data": <the response of /metrics/{cluster}/{jobid}/{metric}, omitted on failure>,
+			"error": string <omitted on success>,
+		}],
+	}
+
+	Queries:
+		job: <cluster>:<jobid>, may be repeated, the + of a heterogeneous job id must be encoded as %2B
+		all queries of the metric's endpoint, they apply to all jobs
+*/
+func (h federatedMetrics) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	_, err := validate_jwt(r)
+	pie(logger.Warn, err, "JWT is invalid", http.StatusForbidden)
+
+	metric := mux.Vars(r)["metric"]
+	if strings.HasSuffix(metric, "/stream") {
+		pie(logger.Warn, herr("Streams cannot be federated", fmt.Sprintf("metric=%v", metric)), "", http.StatusBadRequest)
+	}
+	query := r.URL.Query()
+	jobs := query["job"]
+	query.Del("job")
+	if len(jobs) == 0 || len(jobs) > max_federated_jobs {
+		pie(logger.Warn, herr(fmt.Sprintf("The query `job` must be given between 1 and %v times", max_federated_jobs), fmt.Sprintf("job=%v", jobs)), "", http.StatusBadRequest)
+	}
+	type Result struct {
+		Cluster string          `json:"cluster"`
+		JobId   string          `json:"jobid"`
+		Status  int             `json:"status"`
+		Data    json.RawMessage `json:"data,omitempty"`
+		Error   string          `json:"error,omitempty"`
+	}
+	ret := struct {
+		Results []Result `json:"results"`
+	}{[]Result{}}
+	paths := []string{}
+	for _, job := range jobs {
+		cluster, jobid, found := strings.Cut(job, ":")
+		if !found || jobid == "" || strings.Contains(jobid, "/") {
+			pie(logger.Warn, herr("Failed parsing `job` query. It must be in the format <cluster>:<jobid>", fmt.Sprintf("job=%v", job)), "", http.StatusBadRequest)
+		}
+		if _, err := h.config.GetClusterConfig(cluster); err != nil {
+			pie(logger.Warn, herr(fmt.Sprintf("The cluster %v is unknown", cluster), err.Error()), "", http.StatusBadRequest)
+		}
+		ret.Results = append(ret.Results, Result{Cluster: cluster, JobId: jobid})
+		paths = append(paths, fmt.Sprintf("/metrics/%v/%v/%v", cluster, jobid, metric))
+	}
+
+	for idx, resp := range forward_all(h.router, r, paths, query) {
+		ret.Results[idx].Status = resp.status
+		if resp.status == http.StatusOK {
+			ret.Results[idx].Data = resp.body.Bytes()
+		} else {
+			ret.Results[idx].Error = resp.error_message()
+		}
+	}
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
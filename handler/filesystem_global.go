@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+type filesystemGlobal struct {
+	config     *util.Config
+	backends   backend.Backends
+	filesystem string
+}
+
+func GetCapstorGlobalHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(filesystemGlobal{config, backends, "capstor"})
+}
+
+// the filesystem is taken from the path variable `filesystem`
+func GetFilesystemGlobalHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(filesystemGlobal{config, backends, ""})
+}
+
+/*
+	Returns
+
+	{
+		"time": [int] <epoch-time>,
+		"read_bandwidth": []float <average bytes/sec>,
+		"read_iops": []float <average ops/sec>,
+		"write_bandwidth": []float <average bytes/sec>,
+		"write_iops": []float <average ops/sec>,
+		"metadata_ops": []float <average ops/sec>,
+		"nodes_loadavg": [][5]int <number of nodes with 1-min system loadavg [0,20), [20,40), [40,80), [80,160), [160, inf)>,
+	}
+*/
+func (h filesystemGlobal) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
+
+	h.filesystem = panic_if_unknown_filesystem(r, h.config, h.filesystem)
+
+	logger.Debug().Msgf("Passed all security checks to fetch %v global data for job=%+v in the time window from=%v to=%v", h.filesystem, job, from, to)
+
+	fill := get_fill(r)
+	// the statistics are sums over all OSS/MDS, they cannot be aggregated differently within a time bucket
+	opts := get_query_options(r, from, to, nil)
+	fsstats, err := get_backend(r, h.backends).GetGlobalFilesystem(r.Context(), elastic.Filesystem(strings.ToUpper(h.filesystem)), from, to, opts, logger)
+	pie(logger.Error, err, "Failed getting filesystem stats", http.StatusInternalServerError)
+
+	const unitBw = "Average bytes/s"
+	const unitIops = "Average number operations/s"
+	const unitLoad = "Number of OSS with a 1-min loadavg [[0,20), [20,40), [40,60), [60,80), [80,inf)]"
+
+	ds := get_downsampling(r, len(fsstats.Time), [][]float64{fsstats.ReadBytes, fsstats.ReadIOPS, fsstats.WriteBytes, fsstats.WriteIOPS, fsstats.MetadataOPS})
+	ret := struct {
+		Time            []epochTime    `json:"time"`
+		ReadBytes       nullableSeries `json:"read_bandwidth"`
+		ReadBytesUnit   string         `json:"read_bandwidth_unit"`
+		ReadIOPS        nullableSeries `json:"read_iops"`
+		ReadIOPSUnit    string         `json:"read_iops_unit"`
+		WriteBytes      nullableSeries `json:"write_bandwidth"`
+		WriteBytesUnit  string         `json:"write_bandwidth_unit"`
+		WriteIOPS       nullableSeries `json:"write_iops"`
+		WriteIOPSUnit   string         `json:"write_iops_unit"`
+		MetadataOPS     nullableSeries `json:"metadata_ops"`
+		MetadataOPSUnit string         `json:"metadata_ops_unit"`
+		Load            [][5]int64     `json:"nodes_loadavg"`
+		LoadUnit        string         `json:"nodes_loadavg_unit"`
+	}{as_epoch_array(sample(ds, fsstats.Time)), fill.series(sample(ds, fsstats.ReadBytes)), unitBw, fill.series(sample(ds, fsstats.ReadIOPS)), unitIops, fill.series(sample(ds, fsstats.WriteBytes)), unitBw, fill.series(sample(ds, fsstats.WriteIOPS)), unitIops, fill.series(sample(ds, fsstats.MetadataOPS)), unitIops, sample(ds, fsstats.Load), unitLoad}
+
+	fsstats_bytes, err := json.Marshal(ret)
+	_, _ = w.Write(fsstats_bytes)
+}
+
+// returns the requested filesystem, which is `filesystem` or if empty the path variable `filesystem`
+// panics if the filesystem is not configured for the cluster
+func panic_if_unknown_filesystem(r *http.Request, config *util.Config, filesystem string) string {
+	logger := logging.GetReqLogger(r)
+	vars := mux.Vars(r)
+	if filesystem == "" {
+		filesystem = strings.ToLower(vars["filesystem"])
+	}
+	cluster_config, err := config.GetClusterConfig(vars["system_name"])
+	pie(logger.Warn, err, "", http.StatusBadRequest)
+	if !slices.Contains(cluster_config.Filesystems, filesystem) {
+		pie(logger.Warn, herr("The requested filesystem does not exist on this system", fmt.Sprintf("filesystem=%v, cluster filesystems=%v", filesystem, cluster_config.Filesystems)), "", http.StatusNotFound)
+	}
+	return filesystem
+}
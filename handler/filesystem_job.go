@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+type filesystemJob struct {
+	config   *util.Config
+	backends backend.Backends
+}
+
+func GetFilesystemJobHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(filesystemJob{config, backends})
+}
+
+type fsJobStats struct {
+	ReadBytes   nullableSeries `json:"read_bytes"`
+	WriteBytes  nullableSeries `json:"write_bytes"`
+	ReadIOPS    nullableSeries `json:"read_iops"`
+	WriteIOPS   nullableSeries `json:"write_iops"`
+	MetadataOPS nullableSeries `json:"metadata_ops"`
+}
+
+/*
+	Returns the I/O done by the job itself, from the Lustre jobstats
+
+	{
+		"time": [int] <epoch-time>,
+		"total": {
+			"read_bytes": []float <bytes per time bucket>,
+			"write_bytes": []float <bytes per time bucket>,
+			"read_iops": []float <ops per time bucket>,
+			"write_iops": []float <ops per time bucket>,
+			"metadata_ops": []float <ops per time bucket>,
+		},
+		"targets": {
+			"<OST/MDT name>": {<same fields as total>},
+		},
+		"units": {"<field>": string},
+		"warnings": [string] <only present if the result might be incomplete>,
+	}
+*/
+func (h filesystemJob) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
+	filesystem := panic_if_unknown_filesystem(r, h.config, "")
+
+	logger.Debug().Msgf("Passed all security checks to fetch %v job data for job=%+v in the time window from=%v to=%v", filesystem, job, from, to)
+
+	fill := get_fill(r)
+	// the jobstats are summed within a time bucket, they cannot be aggregated differently
+	opts := get_query_options(r, from, to, nil)
+	fsstats, err := get_backend(r, h.backends).GetJobFilesystem(r.Context(), elastic.Filesystem(strings.ToUpper(filesystem)), job.SlurmId, from, to, opts, logger)
+	pie(logger.Error, err, "Failed getting job filesystem stats", http.StatusInternalServerError)
+
+	const unitBytes = "bytes per time bucket"
+	const unitOps = "number operations per time bucket"
+
+	zeros := func() nullableSeries { return make(nullableSeries, len(fsstats.Time)) }
+	total := fsJobStats{zeros(), zeros(), zeros(), zeros(), zeros()}
+	all := [][]float64{total.ReadBytes, total.WriteBytes, total.ReadIOPS, total.WriteIOPS, total.MetadataOPS}
+	for _, stats := range fsstats.StatsByTarget {
+		for idx := range fsstats.Time {
+			total.ReadBytes[idx] += stats.ReadBytes[idx]
+			total.WriteBytes[idx] += stats.WriteBytes[idx]
+			total.ReadIOPS[idx] += stats.ReadIOPS[idx]
+			total.WriteIOPS[idx] += stats.WriteIOPS[idx]
+			total.MetadataOPS[idx] += stats.MetadataOPS[idx]
+		}
+		all = append(all, stats.ReadBytes, stats.WriteBytes, stats.ReadIOPS, stats.WriteIOPS, stats.MetadataOPS)
+	}
+	ds := get_downsampling(r, len(fsstats.Time), all)
+
+	ret := struct {
+		Time     []epochTime           `json:"time"`
+		Total    fsJobStats            `json:"total"`
+		Targets  map[string]fsJobStats `json:"targets"`
+		Units    map[string]string     `json:"units"`
+		Warnings []string              `json:"warnings,omitempty"`
+	}{
+		Time:    as_epoch_array(sample(ds, fsstats.Time)),
+		Total:   fsJobStats{sample(ds, total.ReadBytes), sample(ds, total.WriteBytes), sample(ds, total.ReadIOPS), sample(ds, total.WriteIOPS), sample(ds, total.MetadataOPS)},
+		Targets: map[string]fsJobStats{},
+		Units: map[string]string{
+			"read_bytes":   unitBytes,
+			"write_bytes":  unitBytes,
+			"read_iops":    unitOps,
+			"write_iops":   unitOps,
+			"metadata_ops": unitOps,
+		},
+		Warnings: fsstats.Warnings.Warnings,
+	}
+	for target, stats := range fsstats.StatsByTarget {
+		ret.Targets[target] = fsJobStats{fill.series(sample(ds, stats.ReadBytes)), fill.series(sample(ds, stats.WriteBytes)), fill.series(sample(ds, stats.ReadIOPS)), fill.series(sample(ds, stats.WriteIOPS)), fill.series(sample(ds, stats.MetadataOPS))}
+	}
+
+	fsstats_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(fsstats_bytes)
+}
@@ -4,56 +4,103 @@ import (
 	"encoding/json"
 	"net/http"
 	"slices"
+	"time"
 
-	"github.com/gorilla/mux"
-
-	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/backend"
 	"cscs.ch/hpcdata/logging"
 	"cscs.ch/hpcdata/util"
 )
 
 type gpuTemperature struct {
 	config   *util.Config
-	esclient *elastic.Client
+	backends backend.Backends
+}
+
+func GetGpuTemperatureHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(gpuTemperature{config, backends})
 }
 
-func GetGpuTemperatureHandler(config *util.Config, esclient *elastic.Client) func(w http.ResponseWriter, r *http.Request) {
-	return wrap(gpuTemperature{config, esclient})
+func GetGpuTemperatureStreamHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(stream{config, backends, gpuTemperature{config, backends}, util.Aggregations})
 }
 
 func (h gpuTemperature) Get(w http.ResponseWriter, r *http.Request) {
 	logger := logging.GetReqLogger(r)
-	job, from, to := panic_if_no_access(r, h.esclient, h.config)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
 
 	logger.Debug().Msgf("Passed all security checks to fetch GPU temperature data for job=%+v in the time window from=%v to=%v", job, from, to)
 
-	vars := mux.Vars(r)
-	nodes := job.Nodes
-	if node_id, exists := vars["node_id"]; exists {
-		nodes = []util.Node{{Nid: node_id}}
-		// security check that the node is part of the job
-		if !slices.ContainsFunc(job.Nodes, func(n util.Node) bool { return n.Nid == node_id }) {
-			pie(logger.Warn, condition_error{"The requested node_id is not part of the job"}, "", http.StatusBadRequest)
-		}
-	}
-	gpuTemp, err := h.esclient.GetGpuTemperature(nodes, from, to, logger)
+	opts := get_query_options(r, from, to, util.Aggregations)
+	ret, _ := h.series(r, job, from, to, opts)
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+func (h gpuTemperature) series(r *http.Request, job *util.Job, from, to time.Time, opts util.QueryOptions) (any, []time.Time) {
+	logger := logging.GetReqLogger(r)
+	nodes := get_nodes(r, job)
+	fill := get_fill(r)
+	gpuTemp, err := get_backend(r, h.backends).GetGpuTemperature(r.Context(), nodes, from, to, opts, logger)
 	pie(logger.Error, err, "Failed getting GPU temperatures", http.StatusInternalServerError)
 
 	type NodeGpuTemperature struct {
-		GpuIndex    int       `json:"gpu_id"`
-		Temperature []float64 `json:"temperature"`
-		Unit        string    `json:"temperature_unit"`
+		GpuIndex    int            `json:"gpu_id"`
+		Temperature nullableSeries `json:"temperature"`
+		Unit        string         `json:"temperature_unit"`
 	}
+	if groups, warnings := get_groups(r, nodes); groups != nil {
+		// the hottest GPU of the group's nodes
+		type GpuTemperatureGroup struct {
+			Temperature nullableSeries `json:"temperature"`
+			Unit        string         `json:"temperature_unit"`
+			Nodes       []string       `json:"nodes"`
+		}
+		ret := struct {
+			Time     []epochTime                    `json:"time"`
+			Groups   map[string]GpuTemperatureGroup `json:"groups"`
+			Xnames   map[string]string              `json:"xnames"`
+			Warnings []string                       `json:"warnings,omitempty"`
+		}{as_epoch_array(gpuTemp.Time), map[string]GpuTemperatureGroup{}, xnames(nodes), slices.Concat(gpuTemp.Warnings.Warnings, warnings)}
+		for _, g := range groups {
+			temperatures := [][]float64{}
+			for _, nid := range g.nids {
+				for _, temps := range gpuTemp.Temperatures[nid] {
+					temperatures = append(temperatures, temps.Temperatures)
+				}
+			}
+			ret.Groups[g.location] = GpuTemperatureGroup{combine_series(temperatures, len(gpuTemp.Time), slices.Max), "°C", g.nids}
+		}
+		all := [][]float64{}
+		for _, group := range ret.Groups {
+			all = append(all, group.Temperature)
+		}
+		ds := get_downsampling(r, len(gpuTemp.Time), all)
+		ret.Time = as_epoch_array(sample(ds, gpuTemp.Time))
+		for location, group := range ret.Groups {
+			group.Temperature = fill.series(sample(ds, group.Temperature))
+			ret.Groups[location] = group
+		}
+		return ret, gpuTemp.Time
+	}
+	all := [][]float64{}
+	for _, gpus := range gpuTemp.Temperatures {
+		for _, temps := range gpus {
+			all = append(all, temps.Temperatures)
+		}
+	}
+	ds := get_downsampling(r, len(gpuTemp.Time), all)
 	ret := struct {
-		Time  []epochTime                     `json:"time"`
-		Nodes map[string][]NodeGpuTemperature `json:"nodes"`
-	}{as_epoch_array(gpuTemp.Time), map[string][]NodeGpuTemperature{}}
+		Time     []epochTime                     `json:"time"`
+		Nodes    map[string][]NodeGpuTemperature `json:"nodes"`
+		Xnames   map[string]string               `json:"xnames"`
+		Warnings []string                        `json:"warnings,omitempty"`
+	}{as_epoch_array(sample(ds, gpuTemp.Time)), map[string][]NodeGpuTemperature{}, xnames(nodes), gpuTemp.Warnings.Warnings}
 	for k, v := range gpuTemp.Temperatures {
 		for _, temps := range v {
-			ret.Nodes[k] = append(ret.Nodes[k], NodeGpuTemperature{GpuIndex: temps.GpuIndex, Temperature: temps.Temperatures, Unit: "°C"})
+			ret.Nodes[k] = append(ret.Nodes[k], NodeGpuTemperature{GpuIndex: temps.GpuIndex, Temperature: fill.series(sample(ds, temps.Temperatures)), Unit: "°C"})
 		}
 	}
-
-	write_bytes, err := json.Marshal(ret)
-	_, _ = w.Write(write_bytes)
+	return ret, gpuTemp.Time
 }
@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 	"unsafe"
@@ -12,7 +15,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
 
-	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/backend"
 	"cscs.ch/hpcdata/firecrest"
 	"cscs.ch/hpcdata/logging"
 	"cscs.ch/hpcdata/util"
@@ -46,10 +49,20 @@ func pie(logFct func() *zerolog.Event, err error, msg string, statuscode int) {
 			msg = err.Error()
 		}
 		if err_herr, canConvert := err.(handler_error); canConvert {
-			logFct().Err(fmt.Errorf(err_herr.logfile_only)).Type("error_type", err_herr).Msg(msg)
+			logFct().Err(errors.New(err_herr.logfile_only)).Type("error_type", err_herr).Msg(msg)
 		} else {
 			logFct().Err(err).Type("error_type", err).Msg(msg)
 		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			msg = "An upstream service did not respond in time. " + msg
+			statuscode = http.StatusGatewayTimeout
+		} else if errors.Is(err, util.ErrInvalidInput) {
+			// e.g. a query of the backend that cannot be served at the requested time resolution
+			statuscode = http.StatusBadRequest
+		} else if errors.Is(err, util.ErrNotSupported) {
+			// e.g. the prometheus backend has no filesystem statistics
+			statuscode = http.StatusNotImplemented
+		}
 		// logging/request_logger will recover from this panic, log it and write to the http.ResponseWriter
 		panic(logging.NewHandlerError(err, msg, statuscode))
 	}
@@ -57,21 +70,149 @@ func pie(logFct func() *zerolog.Event, err error, msg string, statuscode int) {
 
 // implements all security checks whether an API call is allowed to do an API call
 // does not return anything, but panics if any error condition is encountered
-func panic_if_no_access(r *http.Request, esclient *elastic.Client, config *util.Config) (*util.Job, time.Time, time.Time) {
+func panic_if_no_access(r *http.Request, backends backend.Backends, config *util.Config) (*util.Job, time.Time, time.Time) {
 	logger := logging.GetReqLogger(r)
+	ctx := r.Context()
 
-	// check authentication
-	_, err := validate_jwt(r)
-	pie(logger.Warn, err, "JWT is invalid", http.StatusForbidden)
-
-	vars := mux.Vars(r)
+	cluster_config, metrics_backend, f7t_client, user := panic_if_not_authenticated(r, backends, config)
 
-	jobid := vars["job_id"]
+	jobid := mux.Vars(r)["job_id"]
 	if jobid == "" {
 		pie(logger.Warn, herr("The request parameter `jobid` is mandatory", fmt.Sprintf("jobid=`%s`", jobid)), "", http.StatusBadRequest)
 	}
 
-	cluster := vars["system_name"]
+	job, err := get_job(ctx, jobid, cluster_config, f7t_client, metrics_backend, logger)
+	if errors.Is(err, util.ErrInvalidInput) {
+		pie(logger.Warn, err, "", http.StatusBadRequest)
+	} else if errors.Is(err, util.ErrNotSupported) {
+		// e.g. a job that Firecrest no longer lists, on a cluster whose metrics backend does not store jobs
+		pie(logger.Warn, err, "", http.StatusNotImplemented)
+	} else {
+		pie(logger.Error, err, "", http.StatusInternalServerError)
+	}
+
+	if !can_access_account(user, job.Account, config) {
+		pie(logger.Warn, herr("You are not allowed to access the resource. The job's account does not match any of your groups", fmt.Sprintf("account=%v, user's groups=%+v", job.Account, user.Groups)), "", http.StatusUnauthorized)
+	}
+	fill_xnames(job, cluster_config.Inventory)
+
+	what := "job"
+	if component := r.URL.Query().Get("component"); component != "" {
+		job = restrict_to_component(r, job, component)
+		what = "job component"
+	}
+	if step_id := r.URL.Query().Get("job_step"); step_id != "" {
+		job = restrict_to_step(r, job, step_id)
+		what = "job step"
+	}
+
+	from, to := get_time_window(r, job.Start, job.End)
+	if from.Before(job.Start) {
+		pie(logger.Warn, herr(fmt.Sprintf("Your `from` query is before the %v's start time", what), fmt.Sprintf("from=%v, job.Start=%v", from, job.Start)), "", http.StatusBadRequest)
+	}
+	if to.After(job.End) {
+		pie(logger.Warn, herr(fmt.Sprintf("Your `to` query is after the %v's end time", what), fmt.Sprintf("to=%v, job.End=%v", to, job.End)), "", http.StatusBadRequest)
+	}
+
+	return job, from, to
+}
+
+// sets the xnames of all nodes of the job, its components and steps
+func fill_xnames(job *util.Job, inventory *util.Inventory) {
+	inventory.Fill(job.Nodes)
+	for idx := range job.Steps {
+		inventory.Fill(job.Steps[idx].Nodes)
+	}
+	for idx := range job.Components {
+		fill_xnames(&job.Components[idx], inventory)
+	}
+}
+
+// returns the nodes of the job, or only the node of the path variable `node_id`, which is either a nid or an xname
+// panics if the node is not part of the job
+func get_nodes(r *http.Request, job *util.Job) []util.Node {
+	node_id, exists := mux.Vars(r)["node_id"]
+	if !exists {
+		return job.Nodes
+	}
+	// security check that the node is part of the job
+	idx := slices.IndexFunc(job.Nodes, func(n util.Node) bool { return n.Nid == node_id || (n.Xname != "" && n.Xname == node_id) })
+	if idx == -1 {
+		pie(logging.GetReqLogger(r).Warn, condition_error{"The requested node_id is not part of the job"}, "", http.StatusBadRequest)
+	}
+	return []util.Node{job.Nodes[idx]}
+}
+
+// the xnames of the nodes, key==nid. Nodes with an unknown xname are omitted
+func xnames(nodes []util.Node) map[string]string {
+	ret := map[string]string{}
+	for _, n := range nodes {
+		if n.Xname != "" {
+			ret[n.Nid] = n.Xname
+		}
+	}
+	return ret
+}
+
+// returns the allocation of the job selected by the `component` query, which is the index in job.Components
+// panics if the job has no such component
+func restrict_to_component(r *http.Request, job *util.Job, component string) *util.Job {
+	logger := logging.GetReqLogger(r)
+	idx, err := strconv.Atoi(component)
+	if err != nil || idx < 0 {
+		pie(logger.Warn, herr("Failed parsing `component` query. It must be the index of a component of the job", fmt.Sprintf("component=%v, err=%v", component, err)), "", http.StatusBadRequest)
+	}
+	if len(job.Components) == 0 && idx == 0 {
+		// a job with a single allocation is its own component
+		return job
+	}
+	if idx >= len(job.Components) {
+		pie(logger.Warn, herr(fmt.Sprintf("The job has no component %v, it has %v components", idx, max(1, len(job.Components))), fmt.Sprintf("jobid=%v", job.SlurmId)), "", http.StatusNotFound)
+	}
+	return &job.Components[idx]
+}
+
+// returns a copy of the job with the time window and nodes of the Slurm step, selected by the `job_step` query.
+// The step is either the full id (e.g. 1234.0) or the part after the job id (e.g. 0 or batch).
+// panics if the job has no such step
+func restrict_to_step(r *http.Request, job *util.Job, step_id string) *util.Job {
+	logger := logging.GetReqLogger(r)
+	if len(job.Steps) == 0 {
+		pie(logger.Warn, herr("The steps of the job are unknown, they are only available while the job is visible in Firecrest", fmt.Sprintf("jobid=%v, job_step=%v", job.SlurmId, step_id)), "", http.StatusNotFound)
+	}
+	idx := slices.IndexFunc(job.Steps, func(step util.JobStep) bool {
+		return step.Id == step_id || step.Id == job.SlurmId+"."+step_id
+	})
+	if idx == -1 {
+		step_ids := []string{}
+		for _, step := range job.Steps {
+			step_ids = append(step_ids, step.Id)
+		}
+		pie(logger.Warn, herr(fmt.Sprintf("The job has no step %v, its steps are %v", step_id, step_ids), fmt.Sprintf("jobid=%v", job.SlurmId)), "", http.StatusNotFound)
+	}
+
+	step := job.Steps[idx]
+	ret := *job
+	ret.Start = step.Start
+	ret.End = step.End
+	ret.Finished = step.Finished
+	if len(step.Nodes) > 0 {
+		ret.Nodes = step.Nodes
+	}
+	return &ret
+}
+
+// checks the JWT and fetches the caller's userinfo from Firecrest for the request's `system_name`
+// panics if any error condition is encountered
+func panic_if_not_authenticated(r *http.Request, backends backend.Backends, config *util.Config) (*util.ClusterConfig, backend.MetricsBackend, *firecrest.Client, *firecrest.UserInfo) {
+	logger := logging.GetReqLogger(r)
+	ctx := r.Context()
+
+	// check authentication
+	_, err := validate_jwt(r)
+	pie(logger.Warn, err, "JWT is invalid", http.StatusForbidden)
+
+	cluster := mux.Vars(r)["system_name"]
 	if cluster == "" {
 		pie(logger.Warn, herr("The request parameter `cluster` is mandatory", fmt.Sprintf("cluster=`%s`", cluster)), "", http.StatusBadRequest)
 	}
@@ -79,67 +220,182 @@ func panic_if_no_access(r *http.Request, esclient *elastic.Client, config *util.
 	cluster_config, err := config.GetClusterConfig(cluster)
 	pie(logger.Warn, err, "", http.StatusBadRequest)
 
+	metrics_backend, err := backends.Get(cluster)
+	pie(logger.Error, err, "", http.StatusInternalServerError)
+
 	auth := r.Header.Get("Authorization")
-	f7t_client := firecrest.NewClient(cluster_config.F7tURL, cluster_config.Name, auth)
-	user, err := get_cached(auth, logger, func() (*firecrest.UserInfo, time.Duration, error) {
-		u, err := f7t_client.UserInfo()
+	f7t_client := firecrest.NewClient(cluster_config.F7tURL, cluster_config.Name, auth, config.Timeouts.Firecrest)
+	user, err := get_cached(ctx, auth, logger, func() (*firecrest.UserInfo, time.Duration, error) {
+		u, err := f7t_client.UserInfo(ctx)
 		return &u, 1 * time.Hour, err
 	})
 	pie(logger.Warn, err, "Failed fetching userinfo from Firecrest. Did you subscribe to the API?", http.StatusBadRequest)
 	logger.Debug().Msgf("userinfo=%+v", user)
+	return cluster_config, metrics_backend, f7t_client, user
+}
 
-	job, err := get_job(jobid, cluster_config, f7t_client, esclient, logger)
-	if errors.Is(err, util.ErrInvalidInput) {
-		pie(logger.Warn, err, "", http.StatusBadRequest)
-	} else {
-		pie(logger.Error, err, "", http.StatusInternalServerError)
+// whether the user is allowed to access the jobs of account
+func can_access_account(user *firecrest.UserInfo, account string, config *util.Config) bool {
+	if can_access_any_job(user, config) {
+		return true
 	}
+	return slices.ContainsFunc(user.Groups, func(group firecrest.IdNamePair) bool { return group.Name == account })
+}
 
-	can_access := false
-	for _, group := range user.Groups {
-		if group.Name == job.Account || slices.Contains(config.Security.AllowAnyJob, group.Name) {
-			can_access = true
-			break
-		}
-	}
+// whether the user or any of the user's groups is allowed to access any job (`allow_any_job` in the config)
+func can_access_any_job(user *firecrest.UserInfo, config *util.Config) bool {
 	if slices.Contains(config.Security.AllowAnyJob, user.User.Name) {
-		can_access = true
-	}
-
-	if can_access == false {
-		pie(logger.Warn, herr("You are not allowed to access the resource. The job's account does not match any of your groups", fmt.Sprintf("account=%v, user's groups=%+v", job.Account, user.Groups)), "", http.StatusUnauthorized)
+		return true
 	}
+	return slices.ContainsFunc(user.Groups, func(group firecrest.IdNamePair) bool { return slices.Contains(config.Security.AllowAnyJob, group.Name) })
+}
 
+// parses the `from` and `to` queries, which default to default_from and default_to
+// panics if they are invalid
+func get_time_window(r *http.Request, default_from, default_to time.Time) (time.Time, time.Time) {
+	logger := logging.GetReqLogger(r)
 	zhTimezone, err := time.LoadLocation("Europe/Zurich")
 	pie(logger.Error, err, "Failed getting Zurich timezone", http.StatusInternalServerError)
 
-	from := job.Start
+	from := default_from
 	from_query := r.URL.Query().Get("from")
 	if from_query != "" {
 		parsed_time, err := time.ParseInLocation("2006-01-02T15:04:05", from_query, zhTimezone)
 		pie(logger.Warn, err, "Failed parsing `from` query. It must be in the format %Y-%m-%dT%H:%M:%S", http.StatusBadRequest)
-		if parsed_time.Before(job.Start) {
-			pie(logger.Warn, herr("Your `from` query is before the job's start time", fmt.Sprintf("from_query=%v, job.Start=%v", from_query, job.Start)), "", http.StatusBadRequest)
-		}
 		from = parsed_time
 	}
 
-	to := job.End
+	to := default_to
 	to_query := r.URL.Query().Get("to")
 	if to_query != "" {
 		parsed_time, err := time.ParseInLocation("2006-01-02T15:04:05", to_query, zhTimezone)
 		pie(logger.Warn, err, "Failed parsing `to` query. It must be in the format %Y-%m-%dT%H:%M:%S", http.StatusBadRequest)
-		if parsed_time.After(job.End) {
-			pie(logger.Warn, herr("Your `to` query is after the job's end time", fmt.Sprintf("to_query=%v, job.End=%v", to_query, job.End)), "", http.StatusBadRequest)
-		}
 		to = parsed_time
 	}
 
 	if from.After(to) {
 		pie(logger.Warn, herr("Your `from` query is after your `to` query", fmt.Sprintf("from=%v, to=%v", from, to)), "", http.StatusBadRequest)
 	}
+	return from, to
+}
 
-	return job, from, to
+// the maximum number of time buckets a client can request with `step` or `points`, prometheus answers at most 11000
+// points per series. Elasticsearch queries can be limited further by search.max_buckets, see elastic.query_chunked
+const max_points = 10000
+
+// parses the client-selectable time resolution (`step` or `points`) and aggregation (`agg`) of a time series request
+// aggregations are the values of `agg` that the endpoint supports, e.g. counters cannot be aggregated differently
+// panics if any query parameter is invalid
+func get_query_options(r *http.Request, from, to time.Time, aggregations []string) util.QueryOptions {
+	logger := logging.GetReqLogger(r)
+	query := r.URL.Query()
+	ret := util.QueryOptions{}
+
+	if step_query := query.Get("step"); step_query != "" {
+		step, err := time.ParseDuration(step_query)
+		if err != nil || step <= 0 {
+			pie(logger.Warn, herr("Failed parsing `step` query. It must be a positive duration, e.g. 30s or 5m", fmt.Sprintf("step=%v, err=%v", step_query, err)), "", http.StatusBadRequest)
+		}
+		if to.Sub(from)/step > max_points {
+			pie(logger.Warn, herr(fmt.Sprintf("Your `step` query is too small for the time window, at most %v points can be requested", max_points), fmt.Sprintf("step=%v, from=%v, to=%v", step, from, to)), "", http.StatusBadRequest)
+		}
+		ret.Step = step
+	}
+
+	if points_query := query.Get("points"); points_query != "" {
+		if ret.Step > 0 {
+			pie(logger.Warn, herr("The queries `step` and `points` cannot be combined", fmt.Sprintf("step=%v, points=%v", ret.Step, points_query)), "", http.StatusBadRequest)
+		}
+		points, err := strconv.Atoi(points_query)
+		if err != nil || points <= 0 || points > max_points {
+			pie(logger.Warn, herr(fmt.Sprintf("Failed parsing `points` query. It must be an integer between 1 and %v", max_points), fmt.Sprintf("points=%v, err=%v", points_query, err)), "", http.StatusBadRequest)
+		}
+		ret.Points = points
+	}
+
+	if agg_query := query.Get("agg"); agg_query != "" {
+		if len(aggregations) == 0 {
+			pie(logger.Warn, herr("This endpoint does not support the `agg` query", fmt.Sprintf("agg=%v", agg_query)), "", http.StatusBadRequest)
+		}
+		if !slices.Contains(aggregations, agg_query) {
+			pie(logger.Warn, herr(fmt.Sprintf("Invalid `agg` query. It must be one of %v", aggregations), fmt.Sprintf("agg=%v", agg_query)), "", http.StatusBadRequest)
+		}
+		ret.Agg = agg_query
+	}
+
+	return ret
+}
+
+// how time buckets without data are written, selected by the `fill` query
+type fillMethod string
+
+const (
+	fill_null     fillMethod = "null"     // write null (default)
+	fill_zero     fillMethod = "zero"     // write 0
+	fill_previous fillMethod = "previous" // repeat the last value with data, null before the first value
+)
+
+// parses the `fill` query, panics if it is invalid
+func get_fill(r *http.Request) fillMethod {
+	fill := fillMethod(r.URL.Query().Get("fill"))
+	switch fill {
+	case "":
+		return fill_null
+	case fill_null, fill_zero, fill_previous:
+		return fill
+	}
+	pie(logging.GetReqLogger(r).Warn, herr("Invalid `fill` query. It must be one of null, zero or previous", fmt.Sprintf("fill=%v", fill)), "", http.StatusBadRequest)
+	return fill_null
+}
+
+// fills the time buckets without data (NaN, see elastic.NewSeries) of a series
+func (f fillMethod) series(data []float64) nullableSeries {
+	ret := make(nullableSeries, len(data))
+	previous := math.NaN()
+	for idx, v := range data {
+		if math.IsNaN(v) {
+			switch f {
+			case fill_zero:
+				v = 0
+			case fill_previous:
+				v = previous
+			}
+		}
+		ret[idx] = v
+		previous = v
+	}
+	return ret
+}
+
+// a series of a time series response, a time bucket without data (NaN) is written as null
+type nullableSeries []float64
+
+func (s nullableSeries) MarshalJSON() ([]byte, error) {
+	ret := []byte{'['}
+	for idx, v := range s {
+		if idx > 0 {
+			ret = append(ret, ',')
+		}
+		ret = append_nullable(ret, v)
+	}
+	return append(ret, ']'), nil
+}
+
+// a single value of a response, NaN is written as null
+type nullableFloat float64
+
+func (f nullableFloat) MarshalJSON() ([]byte, error) {
+	return append_nullable(nil, float64(f)), nil
+}
+
+func append_nullable(buf []byte, v float64) []byte {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return append(buf, "null"...)
+	} else if abs := math.Abs(v); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		// same formatting as encoding/json
+		return strconv.AppendFloat(buf, v, 'e', -1, 64)
+	}
+	return strconv.AppendFloat(buf, v, 'f', -1, 64)
 }
 
 // This is a helper struct to allow to jsonize an array []time.Time as an array unix epoch (i.e. a json array of integers)
@@ -166,12 +422,20 @@ func as_epoch_array(in []time.Time) []epochTime {
 	return unsafe.Slice((*epochTime)(unsafe.Pointer(&in[0])), len(in))
 }
 
-func get_job(jobid string, cluster_config *util.ClusterConfig, f7t_client *firecrest.Client, esclient *elastic.Client, logger *zerolog.Logger) (*util.Job, error) {
+// returns the metrics backend serving the request's `system_name`
+// panics if there is none, but panic_if_no_access has already verified that the cluster exists
+func get_backend(r *http.Request, backends backend.Backends) backend.MetricsBackend {
+	ret, err := backends.Get(mux.Vars(r)["system_name"])
+	pie(logging.GetReqLogger(r).Error, err, "", http.StatusInternalServerError)
+	return ret
+}
+
+func get_job(ctx context.Context, jobid string, cluster_config *util.ClusterConfig, f7t_client *firecrest.Client, metrics_backend backend.MetricsBackend, logger *zerolog.Logger) (*util.Job, error) {
 	job_key := fmt.Sprintf("%v-%v", cluster_config.Name, jobid)
-	return get_cached(job_key, logger, func() (*util.Job, time.Duration, error) {
-		if job, err := get_job_via_f7t(jobid, f7t_client, logger); err != nil {
+	return get_cached(ctx, job_key, logger, func() (*util.Job, time.Duration, error) {
+		if job, err := get_job_via_f7t(ctx, jobid, f7t_client, logger); err != nil {
 			logger.Warn().Msgf("Failed getting job via firecrest. err=%v", err)
-			if job, err := esclient.GetJob(jobid, cluster_config.ElasticName, logger); err != nil {
+			if job, err := metrics_backend.GetJob(ctx, jobid, cluster_config.ElasticName, logger); err != nil {
 				return nil, 0, err
 			} else {
 				// if it was fetched with Elastic, the job is Finished
@@ -187,25 +451,59 @@ func get_job(jobid string, cluster_config *util.ClusterConfig, f7t_client *firec
 	})
 }
 
-func get_job_via_f7t(jobid string, f7t_client *firecrest.Client, logger *zerolog.Logger) (*util.Job, error) {
-	if f7t_job, err := f7t_client.Job(jobid); err != nil {
+func get_job_via_f7t(ctx context.Context, jobid string, f7t_client *firecrest.Client, logger *zerolog.Logger) (*util.Job, error) {
+	if f7t_jobs, err := f7t_client.Job(ctx, jobid); err != nil {
 		return nil, err
 	} else {
-		logger.Debug().Msgf("Successfully fetched job via firecrest. Job=%#v", f7t_job)
-		submit_account, _ := strings.CutPrefix(f7t_job.Account, "a-")
-		ret := util.Job{
-			SlurmId: f7t_job.JobId,
-			Account: submit_account,
-			Start:   time.Unix(int64(f7t_job.Time.Start), 0),
-		}
-		if f7t_job.Time.End == 0 {
-			ret.End = time.Now()
-			ret.Finished = false
-		} else {
-			ret.End = time.Unix(int64(f7t_job.Time.End), 0)
-			ret.Finished = true
+		logger.Debug().Msgf("Successfully fetched job via firecrest. Jobs=%#v", f7t_jobs)
+		allocations := []util.Job{}
+		for _, f7t_job := range f7t_jobs {
+			allocations = append(allocations, f7t_to_job(f7t_job))
 		}
-		ret.Nodes = util.ExpandNodes(f7t_job.Nodes)
-		return &ret, nil
+		return util.CombineJobs(jobid, allocations), nil
+	}
+}
+
+func f7t_to_job(f7t_job firecrest.Job) util.Job {
+	submit_account, _ := strings.CutPrefix(f7t_job.Account, "a-")
+	ret := util.Job{
+		SlurmId:    f7t_job.JobId,
+		Account:    submit_account,
+		Start:      time.Unix(int64(f7t_job.Time.Start), 0),
+		Name:       f7t_job.Name,
+		User:       f7t_job.User,
+		Partition:  f7t_job.Partition,
+		State:      f7t_job.Status.State,
+		ExitCode:   f7t_job.Status.ExitCode,
+		Signal:     f7t_job.Status.InterruptSignal,
+		WorkingDir: f7t_job.WorkingDir,
+		TimeLimit:  time.Duration(f7t_job.Time.Limit) * time.Minute,
+		Steps:      []util.JobStep{},
+	}
+	ret.End, ret.Finished = f7t_end(f7t_job.Time)
+	ret.Nodes = util.ExpandNodes(f7t_job.Nodes)
+	for _, task := range f7t_job.Tasks {
+		step := util.JobStep{
+			Id:       task.Id,
+			Name:     task.Name,
+			State:    task.Status.State,
+			ExitCode: task.Status.ExitCode,
+			Start:    time.Unix(int64(task.Time.Start), 0),
+			Nodes:    []util.Node{},
+		}
+		step.End, step.Finished = f7t_end(task.Time)
+		if task.Nodes != "" {
+			step.Nodes = util.ExpandNodes(task.Nodes)
+		}
+		ret.Steps = append(ret.Steps, step)
+	}
+	return ret
+}
+
+// the end time and whether it has finished, the end time of a running job or step is the current time
+func f7t_end(t firecrest.JobTaskTime) (time.Time, bool) {
+	if t.End == 0 {
+		return time.Now(), false
 	}
+	return time.Unix(int64(t.End), 0), true
 }
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+type jobMetadata struct {
+	config   *util.Config
+	backends backend.Backends
+}
+
+func GetJobMetadataHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(jobMetadata{config, backends})
+}
+
+/*
+	Returns the Slurm metadata of the job
+
+	{
+		"jobid": string,
+		"name": string,
+		"account": string,
+		"user": string,
+		"partition": string,
+		"state": string,
+		"exit_code": int,
+		"signal": int <the signal that terminated the job, 0 if none>,
+		"working_dir": string,
+		"time_limit": int <seconds, null if unlimited or unknown>,
+		"start": int <epoch-time>,
+		"end": int <epoch-time, the current time if the job is still running>,
+		"finished": bool,
+		"nodes": [string],
+		"xnames": {"<node_id>": string <omitted if the xname is unknown>},
+		"steps": [{
+			"id": string,
+			"name": string,
+			"state": string,
+			"exit_code": int,
+			"start": int <epoch-time>,
+			"end": int <epoch-time, the current time if the step is still running>,
+			"finished": bool,
+			"nodes": [string] <empty if unknown, i.e. the nodes of the job>,
+		}],
+		"components": [{
+			"jobid": string,
+			"state": string,
+			"exit_code": int,
+			"start": int <epoch-time>,
+			"end": int <epoch-time, the current time if the component is still running>,
+			"finished": bool,
+			"nodes": [string],
+		}],
+	}
+
+	A job id with several allocations, i.e. a job array (1234), a heterogeneous job (1234) or a requeued job, spans all
+	its allocations, which are listed as components. A single allocation can be requested with its own job id
+	(e.g. 1234_7 or 1234+1), and the time series endpoints can be restricted to a component with the query
+	`component=<index>`. The components are empty if the job has a single allocation.
+
+	The steps are only known while the job is visible in Firecrest, finished jobs from the accounting have no steps.
+	The time series endpoints can be restricted to a step with the query `job_step=<id>`.
+*/
+func (h jobMetadata) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, _, _ := panic_if_no_access(r, h.backends, h.config)
+
+	logger.Debug().Msgf("Passed all security checks to return the metadata of job=%+v", job)
+
+	type Step struct {
+		Id       string    `json:"id"`
+		Name     string    `json:"name"`
+		State    string    `json:"state"`
+		ExitCode int       `json:"exit_code"`
+		Start    epochTime `json:"start"`
+		End      epochTime `json:"end"`
+		Finished bool      `json:"finished"`
+		Nodes    []string  `json:"nodes"`
+	}
+	type Component struct {
+		JobId    string    `json:"jobid"`
+		State    string    `json:"state"`
+		ExitCode int       `json:"exit_code"`
+		Start    epochTime `json:"start"`
+		End      epochTime `json:"end"`
+		Finished bool      `json:"finished"`
+		Nodes    []string  `json:"nodes"`
+	}
+	ret := struct {
+		JobId      string            `json:"jobid"`
+		Name       string            `json:"name"`
+		Account    string            `json:"account"`
+		User       string            `json:"user"`
+		Partition  string            `json:"partition"`
+		State      string            `json:"state"`
+		ExitCode   int               `json:"exit_code"`
+		Signal     int               `json:"signal"`
+		WorkingDir string            `json:"working_dir"`
+		TimeLimit  *int64            `json:"time_limit"`
+		Start      epochTime         `json:"start"`
+		End        epochTime         `json:"end"`
+		Finished   bool              `json:"finished"`
+		Nodes      []string          `json:"nodes"`
+		Xnames     map[string]string `json:"xnames"`
+		Steps      []Step            `json:"steps"`
+		Components []Component       `json:"components"`
+	}{
+		JobId:      job.SlurmId,
+		Name:       job.Name,
+		Account:    job.Account,
+		User:       job.User,
+		Partition:  job.Partition,
+		State:      job.State,
+		ExitCode:   job.ExitCode,
+		Signal:     job.Signal,
+		WorkingDir: job.WorkingDir,
+		Start:      epochTime{job.Start},
+		End:        epochTime{job.End},
+		Finished:   job.Finished,
+		Nodes:      node_ids(job.Nodes),
+		Xnames:     xnames(job.Nodes),
+		Steps:      []Step{},
+		Components: []Component{},
+	}
+	if job.TimeLimit > 0 {
+		seconds := int64(job.TimeLimit.Seconds())
+		ret.TimeLimit = &seconds
+	}
+	for _, step := range job.Steps {
+		ret.Steps = append(ret.Steps, Step{step.Id, step.Name, step.State, step.ExitCode, epochTime{step.Start}, epochTime{step.End}, step.Finished, node_ids(step.Nodes)})
+	}
+
+	for _, c := range job.Components {
+		ret.Components = append(ret.Components, Component{c.SlurmId, c.State, c.ExitCode, epochTime{c.Start}, epochTime{c.End}, c.Finished, node_ids(c.Nodes)})
+	}
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
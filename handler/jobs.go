@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/firecrest"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+const (
+	default_jobs_per_page = 100
+	max_jobs_per_page     = 1000
+)
+
+type jobList struct {
+	config   *util.Config
+	backends backend.Backends
+}
+
+func GetJobsHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(jobList{config, backends})
+}
+
+/*
+	Returns the caller's jobs (see /jobs/{system_name}/{job_id} for the details of a job) that ran at any time between `from` and `to`, the running jobs first,
+	then the finished jobs, the most recently started first. Firecrest lists only the running jobs of the caller, so the finished jobs
+	are restricted to the caller as well, i.e. the jobs of other members of the caller's accounts are not listed, even if the caller can
+	access them with their job id
+
+	{
+		"jobs": [{
+			"jobid": string,
+			"name": string,
+			"account": string,
+			"state": string,
+			"start": int <epoch-time>,
+			"end": int <epoch-time, the current time if the job is still running>,
+			"nodes": [string],
+			"xnames": {"<node_id>": string <omitted if the xname is unknown>},
+			"finished": bool,
+		}],
+		"total": int <number of jobs over all pages>,
+		"page": int,
+		"per_page": int,
+	}
+
+	Queries:
+		from, to: time window in the format %Y-%m-%dT%H:%M:%S, the default is the last 7 days
+		account: only the caller's jobs of this account
+		page: starting at 1, per_page: default 100, at most 1000
+*/
+func (h jobList) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	ctx := r.Context()
+	cluster_config, metrics_backend, f7t_client, user := panic_if_not_authenticated(r, h.backends, h.config)
+
+	now := time.Now()
+	from, to := get_time_window(r, now.Add(-7*24*time.Hour), now)
+
+	if user.User.Name == "" {
+		pie(logger.Error, condition_error{"Firecrest did not return the name of the user"}, "", http.StatusInternalServerError)
+	}
+	filter := util.JobFilter{From: from, To: to, Accounts: accessible_accounts(user, h.config), User: user.User.Name}
+	if account := r.URL.Query().Get("account"); account != "" {
+		if !can_access_account(user, account, h.config) {
+			pie(logger.Warn, herr("You are not allowed to access the resource. The account does not match any of your groups", fmt.Sprintf("account=%v, user's groups=%+v", account, user.Groups)), "", http.StatusUnauthorized)
+		}
+		filter.Accounts = []string{account}
+	}
+	page := get_positive_int_query(r, "page", 1)
+	per_page := get_positive_int_query(r, "per_page", default_jobs_per_page)
+	if per_page > max_jobs_per_page {
+		pie(logger.Warn, herr(fmt.Sprintf("The query `per_page` must not be larger than %v", max_jobs_per_page), fmt.Sprintf("per_page=%v", per_page)), "", http.StatusBadRequest)
+	}
+	offset := (page - 1) * per_page
+
+	logger.Debug().Msgf("Passed all security checks to list the jobs with filter=%+v, page=%v, per_page=%v", filter, page, per_page)
+
+	warnings := []string{}
+	running := []util.Job{}
+	if f7t_jobs, err := f7t_client.Jobs(ctx); err != nil {
+		logger.Warn().Msgf("Failed getting running jobs via firecrest. err=%v", err)
+		warnings = append(warnings, "The running jobs could not be fetched, only finished jobs are listed")
+	} else {
+		for _, f7t_job := range f7t_jobs {
+			job := f7t_to_job(f7t_job)
+			// pending jobs have no start time yet
+			if f7t_job.Time.Start == 0 || job.Finished || job.Start.After(to) {
+				continue
+			}
+			if filter.Accounts != nil && !slices.Contains(filter.Accounts, job.Account) {
+				continue
+			}
+			running = append(running, job)
+		}
+		slices.SortFunc(running, func(a, b util.Job) int { return b.Start.Compare(a.Start) })
+	}
+
+	// the running jobs come first, the finished jobs fill the rest of the page
+	jobs := []util.Job{}
+	if offset < len(running) {
+		jobs = append(jobs, running[offset:min(offset+per_page, len(running))]...)
+	}
+	filter.Offset = max(0, offset-len(running))
+	filter.Limit = per_page - len(jobs)
+	// a job that has just finished might be listed by both
+	for _, job := range running {
+		filter.Exclude = append(filter.Exclude, job.SlurmId)
+	}
+	total := len(running)
+	finished, err := metrics_backend.ListJobs(ctx, cluster_config.ElasticName, filter, logger)
+	if errors.Is(err, util.ErrNotSupported) {
+		warnings = append(warnings, "The metrics backend of this cluster cannot list finished jobs, only running jobs are listed")
+	} else if errors.Is(err, util.ErrInvalidInput) {
+		pie(logger.Warn, err, "", http.StatusBadRequest)
+	} else {
+		pie(logger.Error, err, "Failed listing jobs", http.StatusInternalServerError)
+		total += finished.Total
+		jobs = append(jobs, finished.Jobs...)
+	}
+
+	type Job struct {
+		JobId    string            `json:"jobid"`
+		Name     string            `json:"name"`
+		Account  string            `json:"account"`
+		State    string            `json:"state"`
+		Start    epochTime         `json:"start"`
+		End      epochTime         `json:"end"`
+		Nodes    []string          `json:"nodes"`
+		Xnames   map[string]string `json:"xnames"`
+		Finished bool              `json:"finished"`
+	}
+	ret := struct {
+		Jobs     []Job    `json:"jobs"`
+		Total    int      `json:"total"`
+		Page     int      `json:"page"`
+		PerPage  int      `json:"per_page"`
+		Warnings []string `json:"warnings,omitempty"`
+	}{[]Job{}, total, page, per_page, warnings}
+	for _, job := range jobs {
+		cluster_config.Inventory.Fill(job.Nodes)
+		ret.Jobs = append(ret.Jobs, Job{job.SlurmId, job.Name, job.Account, job.State, epochTime{job.Start}, epochTime{job.End}, node_ids(job.Nodes), xnames(job.Nodes), job.Finished})
+	}
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+// the accounts whose jobs the user may access, nil if the user may access any job
+func accessible_accounts(user *firecrest.UserInfo, config *util.Config) []string {
+	if can_access_any_job(user, config) {
+		return nil
+	}
+	ret := []string{}
+	for _, group := range user.Groups {
+		ret = append(ret, group.Name)
+	}
+	return ret
+}
+
+// parses an optional query that must be a positive integer
+func get_positive_int_query(r *http.Request, name string, default_value int) int {
+	query := r.URL.Query().Get(name)
+	if query == "" {
+		return default_value
+	}
+	ret, err := strconv.Atoi(query)
+	if err != nil || ret <= 0 {
+		pie(logging.GetReqLogger(r).Warn, herr(fmt.Sprintf("The query `%v` must be a positive integer", name), fmt.Sprintf("%v=%v", name, query)), "", http.StatusBadRequest)
+	}
+	return ret
+}
+
+func node_ids(nodes []util.Node) []string {
+	ret := []string{}
+	for _, n := range nodes {
+		ret = append(ret, n.Nid)
+	}
+	return ret
+}
@@ -3,55 +3,66 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
-	"slices"
+	"time"
 
-	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/backend"
 	"cscs.ch/hpcdata/logging"
 	"cscs.ch/hpcdata/util"
-	"github.com/gorilla/mux"
 )
 
 type memory struct {
 	config   *util.Config
-	esclient *elastic.Client
+	backends backend.Backends
 }
 
-func GetNodeMemoryHandler(config *util.Config, esclient *elastic.Client) func(w http.ResponseWriter, r *http.Request) {
-	return wrap(memory{config, esclient})
+func GetNodeMemoryHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(memory{config, backends})
+}
+
+func GetNodeMemoryStreamHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(stream{config, backends, memory{config, backends}, util.Aggregations})
 }
 
 func (h memory) Get(w http.ResponseWriter, r *http.Request) {
 	logger := logging.GetReqLogger(r)
-	job, from, to := panic_if_no_access(r, h.esclient, h.config)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
 
 	logger.Debug().Msgf("Passed all security checks to fetch memory data data for job=%+v in the time window from=%v to=%v", job, from, to)
 
-	vars := mux.Vars(r)
-	nodes := job.Nodes
-	if node_id, exists := vars["node_id"]; exists {
-		nodes = []util.Node{{Nid: node_id}}
-		// security check that the node is part of the job
-		if !slices.ContainsFunc(job.Nodes, func(n util.Node) bool { return n.Nid == node_id }) {
-			pie(logger.Warn, condition_error{"The requested node_id is not part of the job"}, "", http.StatusBadRequest)
-		}
-	}
-	memoryData, err := h.esclient.GetMemoryData(nodes, from, to, logger)
+	opts := get_query_options(r, from, to, util.Aggregations)
+	ret, _ := h.series(r, job, from, to, opts)
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+func (h memory) series(r *http.Request, job *util.Job, from, to time.Time, opts util.QueryOptions) (any, []time.Time) {
+	logger := logging.GetReqLogger(r)
+	nodes := get_nodes(r, job)
+	fill := get_fill(r)
+	memoryData, err := get_backend(r, h.backends).GetMemoryData(r.Context(), nodes, from, to, opts, logger)
 	pie(logger.Error, err, "Failed getting memory data", http.StatusBadRequest)
 
 	type Memory struct {
-		Free   []float64 `json:"free"`
-		Cache  []float64 `json:"cache"`
-		Buffer []float64 `json:"buffer"`
-		Unit   string    `json:"memory_unit"`
+		Free   nullableSeries `json:"free"`
+		Cache  nullableSeries `json:"cache"`
+		Buffer nullableSeries `json:"buffer"`
+		Unit   string         `json:"memory_unit"`
+	}
+	all := [][]float64{}
+	for _, md := range memoryData.MemoryByNode {
+		all = append(all, md.Free, md.Cache, md.Buffer)
 	}
+	ds := get_downsampling(r, len(memoryData.Time), all)
 	ret := struct {
-		Time  []epochTime       `json:"time"`
-		Nodes map[string]Memory `json:"nodes"`
-	}{as_epoch_array(memoryData.Time), map[string]Memory{}}
+		Time     []epochTime       `json:"time"`
+		Nodes    map[string]Memory `json:"nodes"`
+		Xnames   map[string]string `json:"xnames"`
+		Warnings []string          `json:"warnings,omitempty"`
+	}{as_epoch_array(sample(ds, memoryData.Time)), map[string]Memory{}, xnames(nodes), memoryData.Warnings.Warnings}
 	for nid, md := range memoryData.MemoryByNode {
-		ret.Nodes[nid] = Memory{Free: md.Free, Cache: md.Cache, Buffer: md.Buffer, Unit: "kilobytes"}
+		ret.Nodes[nid] = Memory{Free: fill.series(sample(ds, md.Free)), Cache: fill.series(sample(ds, md.Cache)), Buffer: fill.series(sample(ds, md.Buffer)), Unit: "kilobytes"}
 	}
-
-	write_bytes, err := json.Marshal(ret)
-	_, _ = w.Write(write_bytes)
+	return ret, memoryData.Time
 }
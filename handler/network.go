@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+type network struct {
+	config   *util.Config
+	backends backend.Backends
+}
+
+func GetNodeNetworkHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(network{config, backends})
+}
+
+func GetNodeNetworkStreamHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(stream{config, backends, network{config, backends}, nil})
+}
+
+var networkCounterUnit = map[string]string{
+	"tx_bytes":    "bytes/s",
+	"rx_bytes":    "bytes/s",
+	"tx_packets":  "packets/s",
+	"rx_packets":  "packets/s",
+	"stalls":      "stalls/s",
+	"link_errors": "errors/s",
+}
+
+/*
+	Returns the high-speed network counters, summed over all NICs of a node
+
+	{
+		"time": [int] <epoch-time>,
+		"nodes": {
+			"<node_id>": {
+				"<counter>": []float,
+				"<counter>_unit": string,
+			},
+		},
+		"warnings": [string] <only present if the result might be incomplete>,
+	}
+*/
+func (h network) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
+
+	logger.Debug().Msgf("Passed all security checks to fetch network data for job=%+v in the time window from=%v to=%v", job, from, to)
+
+	// the counters are returned as rates, they cannot be aggregated differently within a time bucket
+	opts := get_query_options(r, from, to, nil)
+	ret, _ := h.series(r, job, from, to, opts)
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+func (h network) series(r *http.Request, job *util.Job, from, to time.Time, opts util.QueryOptions) (any, []time.Time) {
+	logger := logging.GetReqLogger(r)
+	nodes := get_nodes(r, job)
+	fill := get_fill(r)
+	networkData, err := get_backend(r, h.backends).GetNetworkData(r.Context(), nodes, from, to, opts, logger)
+	pie(logger.Error, err, "Failed getting network data", http.StatusInternalServerError)
+
+	all := [][]float64{}
+	for _, counters := range networkData.CountersByNode {
+		all = slices.AppendSeq(all, maps.Values(counters))
+	}
+	ds := get_downsampling(r, len(networkData.Time), all)
+	ret := struct {
+		Time     []epochTime               `json:"time"`
+		Nodes    map[string]map[string]any `json:"nodes"`
+		Xnames   map[string]string         `json:"xnames"`
+		Warnings []string                  `json:"warnings,omitempty"`
+	}{as_epoch_array(sample(ds, networkData.Time)), map[string]map[string]any{}, xnames(nodes), networkData.Warnings.Warnings}
+	for nid, counters := range networkData.CountersByNode {
+		ret.Nodes[nid] = map[string]any{}
+		for counter, values := range counters {
+			ret.Nodes[nid][counter] = fill.series(sample(ds, values))
+			ret.Nodes[nid][counter+"_unit"] = networkCounterUnit[counter]
+		}
+	}
+	return ret, networkData.Time
+}
@@ -2,55 +2,94 @@ package handler
 
 import (
 	"encoding/json"
+	"maps"
 	"net/http"
 	"slices"
+	"time"
 
-	"github.com/gorilla/mux"
-
-	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/backend"
 	"cscs.ch/hpcdata/logging"
 	"cscs.ch/hpcdata/util"
 )
 
 type chassisPower struct {
 	config   *util.Config
-	esclient *elastic.Client
+	backends backend.Backends
+}
+
+func GetChassisPowerHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(chassisPower{config, backends})
 }
 
-func GetChassisPowerHandler(config *util.Config, esclient *elastic.Client) func(w http.ResponseWriter, r *http.Request) {
-	return wrap(chassisPower{config, esclient})
+func GetChassisPowerStreamHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(stream{config, backends, chassisPower{config, backends}, util.Aggregations})
 }
 
 func (h chassisPower) Get(w http.ResponseWriter, r *http.Request) {
 	logger := logging.GetReqLogger(r)
-	job, from, to := panic_if_no_access(r, h.esclient, h.config)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
 
 	logger.Debug().Msgf("Passed all security checks to fetch chassis power data for job=%+v in the time window from=%v to=%v", job, from, to)
 
-	vars := mux.Vars(r)
-	nodes := job.Nodes
-	if node_id, exists := vars["node_id"]; exists {
-		nodes = []util.Node{{Nid: node_id}}
-		// security check that the node is part of the job
-		if !slices.ContainsFunc(job.Nodes, func(n util.Node) bool { return n.Nid == node_id }) {
-			pie(logger.Warn, condition_error{"The requested node_id is not part of the job"}, "", http.StatusBadRequest)
-		}
-	}
-	chassisPower, err := h.esclient.GetChassisPower(nodes, from, to, logger)
+	opts := get_query_options(r, from, to, util.Aggregations)
+	ret, _ := h.series(r, job, from, to, opts)
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+func (h chassisPower) series(r *http.Request, job *util.Job, from, to time.Time, opts util.QueryOptions) (any, []time.Time) {
+	logger := logging.GetReqLogger(r)
+	nodes := get_nodes(r, job)
+	fill := get_fill(r)
+	chassisPower, err := get_backend(r, h.backends).GetChassisPower(r.Context(), nodes, from, to, opts, logger)
 	pie(logger.Error, err, "Failed getting chassis power", http.StatusInternalServerError)
 
 	type ChassisPower struct {
-		Power []float64 `json:"power"`
-		Unit  string    `json:"power_unit"`
+		Power nullableSeries `json:"power"`
+		Unit  string         `json:"power_unit"`
 	}
+	if groups, warnings := get_groups(r, nodes); groups != nil {
+		// the total power of the group's nodes
+		type ChassisPowerGroup struct {
+			ChassisPower
+			Nodes []string `json:"nodes"`
+		}
+		ret := struct {
+			Time     []epochTime                  `json:"time"`
+			Groups   map[string]ChassisPowerGroup `json:"groups"`
+			Xnames   map[string]string            `json:"xnames"`
+			Warnings []string                     `json:"warnings,omitempty"`
+		}{as_epoch_array(chassisPower.Time), map[string]ChassisPowerGroup{}, xnames(nodes), slices.Concat(chassisPower.Warnings.Warnings, warnings)}
+		for _, g := range groups {
+			power := [][]float64{}
+			for _, nid := range g.nids {
+				power = append(power, chassisPower.PowerByNode[nid])
+			}
+			ret.Groups[g.location] = ChassisPowerGroup{ChassisPower{Power: sum_series(power, len(chassisPower.Time)), Unit: "Watt"}, g.nids}
+		}
+		all := [][]float64{}
+		for _, group := range ret.Groups {
+			all = append(all, group.Power)
+		}
+		ds := get_downsampling(r, len(chassisPower.Time), all)
+		ret.Time = as_epoch_array(sample(ds, chassisPower.Time))
+		for location, group := range ret.Groups {
+			group.Power = fill.series(sample(ds, group.Power))
+			ret.Groups[location] = group
+		}
+		return ret, chassisPower.Time
+	}
+	ds := get_downsampling(r, len(chassisPower.Time), slices.Collect(maps.Values(chassisPower.PowerByNode)))
 	ret := struct {
-		Time  []epochTime             `json:"time"`
-		Nodes map[string]ChassisPower `json:"nodes"`
-	}{as_epoch_array(chassisPower.Time), map[string]ChassisPower{}}
+		Time     []epochTime             `json:"time"`
+		Nodes    map[string]ChassisPower `json:"nodes"`
+		Xnames   map[string]string       `json:"xnames"`
+		Warnings []string                `json:"warnings,omitempty"`
+	}{as_epoch_array(sample(ds, chassisPower.Time)), map[string]ChassisPower{}, xnames(nodes), chassisPower.Warnings.Warnings}
 	for nid, power := range chassisPower.PowerByNode {
-		ret.Nodes[nid] = ChassisPower{Power: power, Unit: "Watt"}
+		ret.Nodes[nid] = ChassisPower{Power: fill.series(sample(ds, power)), Unit: "Watt"}
 	}
-
-	write_bytes, err := json.Marshal(ret)
-	_, _ = w.Write(write_bytes)
+	return ret, chassisPower.Time
 }
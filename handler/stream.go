@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+// a new request for a running job is sent at most this often, even if the time buckets are smaller
+const min_stream_interval = 10 * time.Second
+
+// a handler of a time series, which can also be streamed
+type seriesHandler interface {
+	// the response for the job's nodes in the time window and the start of its time buckets, panics on errors
+	series(r *http.Request, job *util.Job, from, to time.Time, opts util.QueryOptions) (any, []time.Time)
+}
+
+type stream struct {
+	config       *util.Config
+	backends     backend.Backends
+	handler      seriesHandler
+	aggregations []string // the supported values of the `agg` query
+}
+
+/*
+	Streams the time series of a job as server-sent events, with the same queries as the non-streaming endpoint
+	except for `to`.
+
+	event: data
+	data: <the response of the non-streaming endpoint>
+
+	The first `data` event contains the time series from the job's start (or `from`) until now. While the job is
+	running, a `data` event with the new time buckets follows every time bucket. It starts with the last time bucket of
+	the previous event, which had not been complete yet, i.e. a time bucket replaces an earlier one with the same time.
+
+	event: end
+	data: {}
+
+	is sent once the job has finished, and
+
+	event: error
+	data: {"message": string}
+
+	if fetching new data failed. The server closes the stream after both.
+*/
+func (h stream) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, from, _ := panic_if_no_access(r, h.backends, h.config)
+	if r.URL.Query().Has("to") {
+		pie(logger.Warn, herr("The query `to` is not supported when streaming", fmt.Sprintf("to=%v", r.URL.Query().Get("to"))), "", http.StatusBadRequest)
+	}
+
+	logger.Debug().Msgf("Passed all security checks to stream data for job=%+v from=%v", job, from)
+
+	// the backfill is answered like a normal request if it fails
+	to := stream_end(job)
+	opts := get_query_options(r, from, to, h.aggregations)
+	ret, timeline := h.handler.series(r, job, from, to, opts)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable buffering in nginx
+	defer func() {
+		// the status code has already been sent, a failure is reported as an event
+		if panicVal := recover(); panicVal != nil {
+			msg := "Internal server error"
+			if h_err, ok := panicVal.(interface{ UserMessage() string }); ok {
+				msg = h_err.UserMessage()
+			}
+			logger.Warn().Msgf("Stopped streaming for job=%v after a failure. err=%v", job.SlurmId, panicVal)
+			_ = write_event(w, rc, "error", map[string]string{"message": msg})
+		}
+	}()
+	if err := write_event(w, rc, "data", ret); err != nil {
+		logger.Debug().Msgf("Failed writing event, the client has probably disconnected. err=%v", err)
+		return
+	}
+
+	for !job.Finished {
+		// the new requests must use the time buckets of the backfill
+		if opts.Step == 0 && len(timeline) > 1 {
+			opts.Step = timeline[1].Sub(timeline[0])
+		}
+		if len(timeline) > 0 {
+			from = timeline[len(timeline)-1]
+		}
+		select {
+		case <-r.Context().Done():
+			logger.Debug().Msgf("The client closed the stream for job=%v", job.SlurmId)
+			return
+		case <-time.After(max(opts.Step, min_stream_interval)):
+		}
+
+		// checks the access again, the JWT might have expired in the meantime
+		job, _, _ = panic_if_no_access(r, h.backends, h.config)
+		ret, timeline = h.handler.series(r, job, from, stream_end(job), opts)
+		if err := write_event(w, rc, "data", ret); err != nil {
+			logger.Debug().Msgf("Failed writing event, the client has probably disconnected. err=%v", err)
+			return
+		}
+	}
+	_ = write_event(w, rc, "end", struct{}{})
+}
+
+// the end of the time window to query, i.e. now for a running job
+func stream_end(job *util.Job) time.Time {
+	if job.Finished {
+		return job.End
+	}
+	return time.Now()
+}
+
+// writes a server-sent event and flushes it to the client
+func write_event(w http.ResponseWriter, rc *http.ResponseController, event string, data any) error {
+	data_bytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event, data_bytes); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"cscs.ch/hpcdata/backend"
+	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+type jobSummary struct {
+	config   *util.Config
+	backends backend.Backends
+}
+
+func GetJobSummaryHandler(config *util.Config, backends backend.Backends) func(w http.ResponseWriter, r *http.Request) {
+	return wrap(jobSummary{config, backends})
+}
+
+// the units of the quantities of a summary
+var summary_units = map[string]string{
+	"wall_time":       "seconds",
+	"cpu":             "%",
+	"memory":          "kilobytes",
+	"gpu_utilization": "%",
+	"gpu_temperature": "°C",
+	"energy":          "Joule",
+	"power":           "Watt",
+}
+
+type summaryStats struct {
+	Avg nullableFloat `json:"avg"`
+	Max nullableFloat `json:"max"`
+}
+type summaryValues struct {
+	CpuUser           summaryStats  `json:"cpu_user"`
+	CpuSystem         summaryStats  `json:"cpu_system"`
+	Cpu               summaryStats  `json:"cpu"` // user + system
+	MinFreeMemory     nullableFloat `json:"min_free_memory"`
+	PeakMemoryUsed    nullableFloat `json:"peak_memory_used"`
+	GpuUtilization    summaryStats  `json:"gpu_utilization"`
+	MaxGpuTemperature nullableFloat `json:"max_gpu_temperature"`
+	Energy            nullableFloat `json:"energy"`
+	AveragePower      nullableFloat `json:"average_power"`
+}
+
+/*
+	Returns single numbers for the whole time window of the job, per node and aggregated over the job's nodes
+
+	{
+		"wall_time": int <seconds>,
+		"job": {
+			"cpu_user": {"avg": float, "max": float},
+			"cpu_system": {"avg": float, "max": float},
+			"cpu": {"avg": float, "max": float} <user + system>,
+			"min_free_memory": float,
+			"peak_memory_used": float <the node memory minus the lowest free, cache and buffer memory, null if the node
+				memory of the cluster is not configured>,
+			"gpu_utilization": {"avg": float, "max": float},
+			"max_gpu_temperature": float,
+			"energy": float,
+			"average_power": float,
+		},
+		"nodes": {
+			"<node_id>": {<same as job>, "gpus": [{"gpu_index": int, "utilization": {"avg": float, "max": float}, "max_temperature": float}]},
+		},
+		"xnames": {"<node_id>": string <omitted if the xname is unknown>},
+		"units": {"<quantity>": string},
+	}
+
+	Averages of the job are the mean over its nodes (resp. GPUs), energy and average power are the sum over its nodes,
+	the peak memory used is the maximum over its nodes. A value without any data is null.
+*/
+func (h jobSummary) Get(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetReqLogger(r)
+	job, from, to := panic_if_no_access(r, h.backends, h.config)
+
+	logger.Debug().Msgf("Passed all security checks to fetch the job summary for job=%+v in the time window from=%v to=%v", job, from, to)
+
+	nodes := get_nodes(r, job)
+	summary, err := get_backend(r, h.backends).GetJobSummary(r.Context(), nodes, from, to, logger)
+	pie(logger.Error, err, "Failed getting job summary", http.StatusInternalServerError)
+	node_memory := get_node_memory(r, h.config)
+	if node_memory <= 0 {
+		summary.Warnings.Warnings = append(summary.Warnings.Warnings, "The peak memory used is unknown, the node memory of the cluster is not configured")
+	}
+
+	type Gpu struct {
+		GpuIndex       int           `json:"gpu_index"`
+		Utilization    summaryStats  `json:"utilization"`
+		MaxTemperature nullableFloat `json:"max_temperature"`
+	}
+	type Node struct {
+		summaryValues
+		Gpus []Gpu `json:"gpus"`
+	}
+	ret := struct {
+		WallTime int64             `json:"wall_time"`
+		Job      summaryValues     `json:"job"`
+		Nodes    map[string]Node   `json:"nodes"`
+		Xnames   map[string]string `json:"xnames"`
+		Units    map[string]string `json:"units"`
+		Warnings []string          `json:"warnings,omitempty"`
+	}{
+		WallTime: int64(to.Sub(from).Seconds()),
+		Nodes:    map[string]Node{},
+		Xnames:   xnames(nodes),
+		Units:    summary_units,
+		Warnings: summary.Warnings.Warnings,
+	}
+
+	allNodes := []elastic.NodeSummary{}
+	allGpus := []elastic.GpuSummary{}
+	for nid, ns := range summary.SummaryByNode {
+		node := Node{summaryValues: summarize([]elastic.NodeSummary{*ns}, ns.Gpus, node_memory), Gpus: []Gpu{}}
+		for _, gpu := range ns.Gpus {
+			node.Gpus = append(node.Gpus, Gpu{GpuIndex: gpu.GpuIndex, Utilization: as_summary_stats(gpu.Utilization), MaxTemperature: nullableFloat(gpu.MaxTemperature)})
+		}
+		ret.Nodes[nid] = node
+		allNodes = append(allNodes, *ns)
+		allGpus = append(allGpus, ns.Gpus...)
+	}
+	ret.Job = summarize(allNodes, allGpus, node_memory)
+
+	write_bytes, err := json.Marshal(ret)
+	pie(logger.Error, err, "Failed converting data to JSON return value", http.StatusInternalServerError)
+	_, _ = w.Write(write_bytes)
+}
+
+// the memory of a node of the request's cluster in kilobytes (the unit of the memory series), 0 if it is not configured
+func get_node_memory(r *http.Request, config *util.Config) float64 {
+	cluster_config, err := config.GetClusterConfig(mux.Vars(r)["system_name"])
+	pie(logging.GetReqLogger(r).Error, err, "", http.StatusInternalServerError)
+	return cluster_config.NodeMemory * 1024 * 1024
+}
+
+// aggregates the summaries of several nodes, values without data (NaN) are ignored.
+// node_memory is the memory of a node in kilobytes, 0 if it is unknown.
+func summarize(nodes []elastic.NodeSummary, gpus []elastic.GpuSummary, node_memory float64) summaryValues {
+	cpuUser, cpuSystem, cpuBusy, gpuUtilization := []elastic.Stats{}, []elastic.Stats{}, []elastic.Stats{}, []elastic.Stats{}
+	minFree, peakUsed, maxTemperature, energy, power := math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()
+	for _, n := range nodes {
+		cpuUser = append(cpuUser, n.CpuUser)
+		cpuSystem = append(cpuSystem, n.CpuSystem)
+		cpuBusy = append(cpuBusy, n.CpuBusy)
+		if used := node_memory - n.MinAvailableMemory; node_memory > 0 && !math.IsNaN(used) && (math.IsNaN(peakUsed) || used > peakUsed) {
+			peakUsed = used
+		}
+		if !math.IsNaN(n.Power.Avg) {
+			power = elastic.SumIgnoringNaN(power, n.Power.Avg)
+		}
+		if !math.IsNaN(n.MinFreeMemory) && (math.IsNaN(minFree) || n.MinFreeMemory < minFree) {
+			minFree = n.MinFreeMemory
+		}
+		if !math.IsNaN(n.Energy) {
+			energy = elastic.SumIgnoringNaN(energy, n.Energy)
+		}
+	}
+	for _, g := range gpus {
+		gpuUtilization = append(gpuUtilization, g.Utilization)
+		if !math.IsNaN(g.MaxTemperature) && (math.IsNaN(maxTemperature) || g.MaxTemperature > maxTemperature) {
+			maxTemperature = g.MaxTemperature
+		}
+	}
+
+	return summaryValues{
+		CpuUser:           mean_of_stats(cpuUser),
+		CpuSystem:         mean_of_stats(cpuSystem),
+		Cpu:               mean_of_stats(cpuBusy),
+		MinFreeMemory:     nullableFloat(minFree),
+		PeakMemoryUsed:    nullableFloat(peakUsed),
+		GpuUtilization:    mean_of_stats(gpuUtilization),
+		MaxGpuTemperature: nullableFloat(maxTemperature),
+		Energy:            nullableFloat(energy),
+		AveragePower:      nullableFloat(power),
+	}
+}
+
+// the mean of the averages and the maximum of the maxima
+func mean_of_stats(in []elastic.Stats) summaryStats {
+	avgs, maxs := []float64{}, []float64{}
+	for _, s := range in {
+		avgs = append(avgs, s.Avg)
+		maxs = append(maxs, s.Max)
+	}
+	return summaryStats{nullableFloat(elastic.SeriesStats(avgs).Avg), nullableFloat(elastic.SeriesStats(maxs).Max)}
+}
+
+func as_summary_stats(in elastic.Stats) summaryStats {
+	return summaryStats{nullableFloat(in.Avg), nullableFloat(in.Max)}
+}
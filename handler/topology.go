@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+
+	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+// the nodes at the same physical location, see get_groups
+type nodeGroup struct {
+	location string // e.g. x1000c0 for a chassis
+	nids     []string
+}
+
+// groups the nodes by the topology level of the `group_by` query (blade, chassis or cabinet).
+// Returns nil if the query is not set. Nodes without a known xname are not part of any group, they are reported as a warning.
+func get_groups(r *http.Request, nodes []util.Node) ([]nodeGroup, []string) {
+	level := r.URL.Query().Get("group_by")
+	if level == "" {
+		return nil, nil
+	}
+	if !slices.Contains(util.TopologyLevels, level) {
+		pie(logging.GetReqLogger(r).Warn, herr(fmt.Sprintf("Invalid `group_by` query. It must be one of %v", util.TopologyLevels), fmt.Sprintf("group_by=%v", level)), "", http.StatusBadRequest)
+	}
+
+	ret := []nodeGroup{}
+	unknown := []string{}
+	for _, n := range nodes {
+		location, ok := util.Location(n.Xname, level)
+		if !ok {
+			unknown = append(unknown, n.Nid)
+			continue
+		}
+		idx := slices.IndexFunc(ret, func(g nodeGroup) bool { return g.location == location })
+		if idx < 0 {
+			idx = len(ret)
+			ret = append(ret, nodeGroup{location: location})
+		}
+		ret[idx].nids = append(ret[idx].nids, n.Nid)
+	}
+	if len(unknown) > 0 {
+		return ret, []string{fmt.Sprintf("The xnames of the nodes %v are unknown, they are not part of any group", unknown)}
+	}
+	return ret, nil
+}
+
+// combines several series time bucket by time bucket, time buckets without data (NaN) are ignored
+func combine_series(series [][]float64, n int, combine func(values []float64) float64) []float64 {
+	ret := elastic.NewSeries(n)
+	values := []float64{}
+	for idx := range ret {
+		values = values[:0]
+		for _, s := range series {
+			if idx < len(s) && !math.IsNaN(s[idx]) {
+				values = append(values, s[idx])
+			}
+		}
+		if len(values) > 0 {
+			ret[idx] = combine(values)
+		}
+	}
+	return ret
+}
+
+// sums several series time bucket by time bucket. Unlike combine_series, a time bucket has no data (NaN) if any of
+// the series has no data, the sum of only some nodes would understate the total.
+func sum_series(series [][]float64, n int) []float64 {
+	ret := make([]float64, n)
+	for _, s := range series {
+		for idx := range ret {
+			if idx < len(s) {
+				ret[idx] += s[idx]
+			} else {
+				ret[idx] = math.NaN()
+			}
+		}
+	}
+	return ret
+}
+
+func sum(values []float64) float64 {
+	ret := 0.0
+	for _, v := range values {
+		ret += v
+	}
+	return ret
+}
+
+func mean(values []float64) float64 {
+	return sum(values) / float64(len(values))
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
 
-	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/backend"
 	"cscs.ch/hpcdata/handler"
 	"cscs.ch/hpcdata/logging"
 	"cscs.ch/hpcdata/util"
@@ -36,39 +36,81 @@ func main() {
 	flag.Parse()
 	config := util.ReadConfig(configpath)
 
-	db := util.NewDb(config.GetDBPath())
+	db := util.NewDb(config.GetDBPath(), config.Timeouts.Database)
 
-	esclient := elastic.NewClient(config)
+	backends := backend.NewBackends(config)
 
-	handler.InitRedis(config.RedisConfig)
+	handler.InitRedis(config.RedisConfig, config.Timeouts.Redis)
 
 	for _, jwt_signing_url := range config.OauthSigners {
 		handler.PrepareJwksKeyfunc(jwt_signing_url)
 	}
 
 	reqHandler := mux.NewRouter()
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/capstor/global", handler.GetCapstorGlobalHandler(config, esclient))
+	reqHandler.HandleFunc("/jobs/{system_name}", handler.GetJobsHandler(config, backends))
+	reqHandler.HandleFunc("/jobs/{system_name}/{job_id}", handler.GetJobMetadataHandler(config, backends))
+	reqHandler.HandleFunc("/compare/{system_name}", handler.GetCompareHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/capstor/global", handler.GetCapstorGlobalHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/fs/{filesystem}/global", handler.GetFilesystemGlobalHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/fs/{filesystem}/job", handler.GetFilesystemJobHandler(config, backends))
 
 	// TODO: Should this just come from DCGM metrics?
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/gpu/temperature", handler.GetGpuTemperatureHandler(config, esclient))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/gpu/temperature", handler.GetGpuTemperatureHandler(config, esclient))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/gpu/temperature/stream", handler.GetGpuTemperatureStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/gpu/temperature", handler.GetGpuTemperatureHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/gpu/temperature/stream", handler.GetGpuTemperatureStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/gpu/temperature", handler.GetGpuTemperatureHandler(config, backends))
 
 	// DCGM data
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/gpu/utilization", handler.GetDcgmData(config, esclient, "gpu_utilization"))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/gpu/utilization", handler.GetDcgmData(config, esclient, "gpu_utilization"))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/gpu/utilization/stream", handler.GetDcgmStreamHandler(config, backends, "gpu_utilization"))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/gpu/utilization", handler.GetDcgmData(config, backends, "gpu_utilization"))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/gpu/utilization/stream", handler.GetDcgmStreamHandler(config, backends, "gpu_utilization"))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/gpu/utilization", handler.GetDcgmData(config, backends, "gpu_utilization"))
 
 	// global node data
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/memory", handler.GetNodeMemoryHandler(config, esclient))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/memory", handler.GetNodeMemoryHandler(config, esclient))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/cpu", handler.GetNodeCpuHandler(config, esclient))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/cpu", handler.GetNodeCpuHandler(config, esclient))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/energy", handler.GetChassisEnergyHandler(config, esclient))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/energy", handler.GetChassisEnergyHandler(config, esclient))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/power", handler.GetChassisPowerHandler(config, esclient))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/power", handler.GetChassisPowerHandler(config, esclient))
-
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/custom", handler.GetCustomMetricHandler(config, esclient, &db))
-	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/custom", handler.GetCustomMetricHandler(config, esclient, &db))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/memory/stream", handler.GetNodeMemoryStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/memory", handler.GetNodeMemoryHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/memory/stream", handler.GetNodeMemoryStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/memory", handler.GetNodeMemoryHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/cpu/stream", handler.GetNodeCpuStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/cpu", handler.GetNodeCpuHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/cpu/stream", handler.GetNodeCpuStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/cpu", handler.GetNodeCpuHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/energy", handler.GetChassisEnergyHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/energy", handler.GetChassisEnergyHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/power/stream", handler.GetChassisPowerStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/power", handler.GetChassisPowerHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/power/stream", handler.GetChassisPowerStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/power", handler.GetChassisPowerHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/network/stream", handler.GetNodeNetworkStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/node/network", handler.GetNodeNetworkHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/network/stream", handler.GetNodeNetworkStreamHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/node/network", handler.GetNodeNetworkHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/energy/report", handler.GetEnergyReportHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/energy/report", handler.GetEnergyReportHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/diagnostics", handler.GetDiagnosticsHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/summary", handler.GetJobSummaryHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/summary", handler.GetJobSummaryHandler(config, backends))
+
+	// metrics declared in the metric catalog of the config file
+	for _, metric := range config.Catalog {
+		reqHandler.HandleFunc(fmt.Sprintf("/metrics/{system_name}/{job_id}/%v", metric.Path), handler.GetCatalogMetricHandler(config, backends, metric))
+		reqHandler.HandleFunc(fmt.Sprintf("/metrics/{system_name}/{job_id}/{node_id}/%v", metric.Path), handler.GetCatalogMetricHandler(config, backends, metric))
+	}
+
+	// any allowlisted DCGM metric, registered after the catalog, such that catalog entries below gpu/ take precedence
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/gpu", handler.GetDcgmMetricsHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/gpu", handler.GetDcgmMetricsHandler(config, backends))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/gpu/{dcgm_metric}/stream", handler.GetDcgmStreamHandler(config, backends, ""))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/gpu/{dcgm_metric}", handler.GetDcgmData(config, backends, ""))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/gpu/{dcgm_metric}/stream", handler.GetDcgmStreamHandler(config, backends, ""))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/gpu/{dcgm_metric}", handler.GetDcgmData(config, backends, ""))
+
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/custom", handler.GetCustomMetricHandler(config, backends, &db))
+	reqHandler.HandleFunc("/metrics/{system_name}/{job_id}/{node_id}/custom", handler.GetCustomMetricHandler(config, backends, &db))
+
+	// federated requests are forwarded to the router for each cluster
+	reqHandler.HandleFunc("/federated/jobs", handler.GetFederatedJobsHandler(config, reqHandler))
+	reqHandler.HandleFunc("/federated/metrics/{metric:.+}", handler.GetFederatedMetricsHandler(config, reqHandler))
 
 	reqHandler.HandleFunc("/test", handler.GetTestHandler())
 
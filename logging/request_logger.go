@@ -31,6 +31,11 @@ func (h handler_error) Error() string {
 	return h.err.Error()
 }
 
+// the message for the user, the error itself is only logged
+func (h handler_error) UserMessage() string {
+	return h.usermsg
+}
+
 type requestFileHook struct {
 	logger zerolog.Logger
 	xid    string
@@ -55,6 +60,11 @@ func newLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
 	return &loggingResponseWriter{w, http.StatusOK}
 }
 
+// allows http.ResponseController to flush the real response writer, e.g. for server-sent events
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	// remember response code and forward to the real response writer
 	lrw.statusCode = code
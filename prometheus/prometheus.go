@@ -0,0 +1,582 @@
+package prometheus
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cscs.ch/hpcdata/elastic"
+	"cscs.ch/hpcdata/logging"
+	"cscs.ch/hpcdata/util"
+)
+
+const wanted_num_timestamps = 5000
+
+// prometheus rejects range queries with more points per series
+const max_points_per_series = 11000
+
+// maps the DCGM metric names used in the API to the metric names exported by dcgm-exporter
+var dcgmMetricNames = map[string]string{
+	"gpu_temp":        "DCGM_FI_DEV_GPU_TEMP",
+	"gpu_utilization": "DCGM_FI_DEV_GPU_UTIL",
+	"fb_used":         "DCGM_FI_DEV_FB_USED",
+	"sm_clock":        "DCGM_FI_DEV_SM_CLOCK",
+	"power_usage":     "DCGM_FI_DEV_POWER_USAGE",
+	"tensor_active":   "DCGM_FI_PROF_PIPE_TENSOR_ACTIVE",
+	"pcie_tx_bytes":   "DCGM_FI_PROF_PCIE_TX_BYTES",
+	"pcie_rx_bytes":   "DCGM_FI_PROF_PCIE_RX_BYTES",
+	"nvlink_tx_bytes": "DCGM_FI_PROF_NVLINK_TX_BYTES",
+	"nvlink_rx_bytes": "DCGM_FI_PROF_NVLINK_RX_BYTES",
+	"xid_errors":      "DCGM_FI_DEV_XID_ERRORS",
+}
+
+type Client struct {
+	baseURL       string
+	authorization string
+	nodeLabel     string
+	gpuNodeLabel  string
+	powerMetric   string
+	timeout       time.Duration // of a single request
+}
+
+func NewClient(config util.PrometheusConfig, timeout time.Duration) *Client {
+	c := Client{
+		baseURL:      strings.TrimRight(config.URL, "/"),
+		nodeLabel:    config.NodeLabel,
+		gpuNodeLabel: config.GpuNodeLabel,
+		powerMetric:  config.PowerMetric,
+		timeout:      timeout,
+	}
+	if config.Username != "" {
+		c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(config.Username+":"+config.Password))
+	}
+	if c.nodeLabel == "" {
+		c.nodeLabel = "instance"
+	}
+	if c.gpuNodeLabel == "" {
+		c.gpuNodeLabel = "Hostname"
+	}
+	if c.powerMetric == "" {
+		c.powerMetric = "node_hwmon_power_average_watt"
+	}
+	return &c
+}
+
+// Jobs are not stored in prometheus, they must be fetched from Firecrest
+func (c *Client) GetJob(ctx context.Context, jobid string, cluster_name string, logger *zerolog.Logger) (*util.Job, error) {
+	return nil, fmt.Errorf("The prometheus backend cannot look up jobs, jobid=%v cluster=%v - %w", jobid, cluster_name, util.ErrNotSupported)
+}
+
+func (c *Client) ListJobs(ctx context.Context, cluster_name string, filter util.JobFilter, logger *zerolog.Logger) (*util.JobList, error) {
+	return nil, fmt.Errorf("The prometheus backend cannot list jobs, cluster=%v - %w", cluster_name, util.ErrNotSupported)
+}
+
+func (c *Client) GetGlobalFilesystem(ctx context.Context, fs elastic.Filesystem, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.FilesystemStats, error) {
+	return nil, fmt.Errorf("The prometheus backend does not provide filesystem statistics for fs=%v - %w", fs, util.ErrNotSupported)
+}
+
+func (c *Client) GetJobFilesystem(ctx context.Context, fs elastic.Filesystem, jobid string, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.JobFilesystemStats, error) {
+	return nil, fmt.Errorf("The prometheus backend does not provide job filesystem statistics for fs=%v - %w", fs, util.ErrNotSupported)
+}
+
+func (c *Client) GetGpuTemperature(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.GpuTemperatures, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	// like in the elastic backend the temperature is by default the maximum within a time bucket
+	opts.Agg = cmp.Or(opts.Agg, "max")
+	dcgmData, err := c.GetDcgmData(ctx, nodes, from, to, "gpu_temp", opts, logger)
+	if err != nil {
+		return nil, fmt.Errorf("Failed GPU temperature query in prometheus: %w", err)
+	}
+
+	ret := elastic.GpuTemperatures{Time: dcgmData.Time, Temperatures: map[string][]elastic.GpuTemperatureIndexed{}}
+	for node_id, gpus := range dcgmData.MetricByNode {
+		for _, gpu := range gpus {
+			ret.Temperatures[node_id] = append(ret.Temperatures[node_id], elastic.GpuTemperatureIndexed{GpuIndex: gpu.GpuIndex, Temperatures: gpu.Data})
+		}
+	}
+	return &ret, nil
+}
+
+// There is no chassis energy counter in node_exporter, the energy is the integrated power relative to the first bucket
+func (c *Client) GetChassisEnergy(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.ChassisEnergy, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	power, err := c.GetChassisPower(ctx, nodes, from, to, opts, logger)
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting node's energy in prometheus: %w", err)
+	}
+
+	ret := elastic.ChassisEnergy{Time: power.Time, EnergyByNode: map[string][]float64{}}
+	for node_id, values := range power.PowerByNode {
+		ret.EnergyByNode[node_id] = integrate(power.Time, values)
+	}
+	return &ret, nil
+}
+
+// the energy relative to the first bucket of a power series
+// buckets without power add no energy, and have no energy value themselves
+func integrate(timeline []time.Time, power []float64) []float64 {
+	energy := elastic.NewSeries(len(power))
+	total := 0.0
+	for idx := range power {
+		if idx > 0 && !math.IsNaN(power[idx-1]) {
+			total += power[idx-1] * timeline[idx].Sub(timeline[idx-1]).Seconds()
+		}
+		if !math.IsNaN(power[idx]) {
+			energy[idx] = total
+		}
+	}
+	return energy
+}
+
+func (c *Client) GetChassisPower(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.ChassisPower, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	step := get_step(from, to, 10*time.Second, opts)
+	query := fmt.Sprintf("sum by (%v) (%v)", c.nodeLabel, over_time(cmp.Or(opts.Agg, "avg"), fmt.Sprintf("%[2]v{%[1]v=~%[3]v}[%[4]v]", c.nodeLabel, c.powerMetric, nodes_regex(nodes), promql_duration(step))))
+	series, err := c.query_range(ctx, query, from, to, step, logger)
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting node's power in prometheus: %w", err)
+	}
+	logger.Debug().Msgf("Querying chassis power from prometheus returned %v series", len(series))
+
+	ret := elastic.ChassisPower{Time: timeline(from, to, step), PowerByNode: map[string][]float64{}}
+	for _, n := range nodes {
+		ret.PowerByNode[n.Nid] = elastic.NewSeries(len(ret.Time))
+	}
+	for _, s := range series {
+		node_id := node_name(s.Metric[c.nodeLabel])
+		if _, ok := ret.PowerByNode[node_id]; ok {
+			s.fill(ret.PowerByNode[node_id], from, step)
+		}
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetDcgmData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, metric string, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.DcgmMetric, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	metric_name, ok := dcgmMetricNames[metric]
+	if !ok {
+		return nil, fmt.Errorf("The DCGM metric %v is not known to the prometheus backend - %w", metric, util.ErrInvalidInput)
+	}
+
+	step := get_step(from, to, 10*time.Second, opts)
+	query := over_time(cmp.Or(opts.Agg, "avg"), fmt.Sprintf("%v{%v=~%v}[%v]", metric_name, c.gpuNodeLabel, nodes_regex(nodes), promql_duration(step)))
+	series, err := c.query_range(ctx, query, from, to, step, logger)
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting node's DCGM metric %v, when querying prometheus: %w", metric, err)
+	}
+	logger.Debug().Msgf("Querying DCGM metrics from prometheus returned %v series", len(series))
+
+	ret := elastic.DcgmMetric{Time: timeline(from, to, step), MetricByNode: map[string][]elastic.DcgmDataIndexed{}}
+	for _, s := range series {
+		node_id := node_name(s.Metric[c.gpuNodeLabel])
+		gpuIdx, err := strconv.Atoi(s.Metric["gpu"])
+		if err != nil {
+			logger.Warn().Err(err).Msgf("Ignoring DCGM series without a valid gpu label. labels=%v", s.Metric)
+			continue
+		}
+		// ensure that every GPU index up to gpuIdx exists, such that the slice index matches the GPU index
+		for len(ret.MetricByNode[node_id]) <= gpuIdx {
+			ret.MetricByNode[node_id] = append(ret.MetricByNode[node_id], elastic.DcgmDataIndexed{GpuIndex: len(ret.MetricByNode[node_id]), Data: elastic.NewSeries(len(ret.Time))})
+		}
+		s.fill(ret.MetricByNode[node_id][gpuIdx].Data, from, step)
+	}
+	return &ret, nil
+}
+
+// GetDcgmMetricNames returns the DCGM metrics (API names, see dcgmMetricNames) that have data for any of the nodes in the time window
+func (c *Client) GetDcgmMetricNames(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) ([]string, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	params := url.Values{}
+	params.Set("match[]", fmt.Sprintf("{__name__=~\"DCGM_FI_.*\",%v=~%v}", c.gpuNodeLabel, nodes_regex(nodes)))
+	params.Set("start", strconv.FormatInt(from.Unix(), 10))
+	params.Set("end", strconv.FormatInt(to.Unix(), 10))
+	// the label values endpoint does not accept POST requests, therefore the names are taken from the matching series
+	var labelSets []map[string]string
+	if err := c.request(ctx, "api/v1/series", params, &labelSets, logger); err != nil {
+		return nil, fmt.Errorf("Failed getting available DCGM metrics from prometheus: %w", err)
+	}
+
+	ret := []string{}
+	for metric, metric_name := range dcgmMetricNames {
+		if slices.ContainsFunc(labelSets, func(labels map[string]string) bool { return labels["__name__"] == metric_name }) {
+			ret = append(ret, metric)
+		}
+	}
+	slices.Sort(ret)
+	return ret, nil
+}
+
+func (c *Client) GetMemoryData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.MemoryData, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	step := get_step(from, to, 30*time.Second, opts)
+	ret := elastic.MemoryData{MemoryByNode: map[string]*elastic.Memory{}}
+	for _, n := range nodes {
+		ret.MemoryByNode[n.Nid] = &elastic.Memory{}
+	}
+	// the API reports memory in kilobytes, node_exporter in bytes
+	queries := []struct {
+		metric string
+		agg    string // default aggregation within a time bucket
+		target func(m *elastic.Memory) *[]float64
+	}{
+		{"node_memory_MemFree_bytes", "min", func(m *elastic.Memory) *[]float64 { return &m.Free }},
+		{"node_memory_Cached_bytes", "max", func(m *elastic.Memory) *[]float64 { return &m.Cache }},
+		{"node_memory_Buffers_bytes", "max", func(m *elastic.Memory) *[]float64 { return &m.Buffer }},
+	}
+	ret.Time = timeline(from, to, step)
+	for _, q := range queries {
+		query := over_time(cmp.Or(opts.Agg, q.agg), fmt.Sprintf("%v{%v=~%v}[%v]", q.metric, c.nodeLabel, nodes_regex(nodes), promql_duration(step))) + " / 1024"
+		series, err := c.query_range(ctx, query, from, to, step, logger)
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting node's memory in prometheus: %w", err)
+		}
+		for _, m := range ret.MemoryByNode {
+			*q.target(m) = elastic.NewSeries(len(ret.Time))
+		}
+		for _, s := range series {
+			if m, ok := ret.MemoryByNode[node_name(s.Metric[c.nodeLabel])]; ok {
+				s.fill(*q.target(m), from, step)
+			}
+		}
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetCpuData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.CpuData, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	step := get_step(from, to, 30*time.Second, opts)
+	// rate() needs at least two samples, i.e. the window must be larger than the scrape interval
+	window := max(step, time.Minute)
+	ret := elastic.CpuData{Time: timeline(from, to, step), CpuByNode: map[string]*elastic.Cpu{}}
+	for _, n := range nodes {
+		ret.CpuByNode[n.Nid] = &elastic.Cpu{User: elastic.NewSeries(len(ret.Time)), System: elastic.NewSeries(len(ret.Time))}
+	}
+	for _, mode := range []string{"user", "system"} {
+		query := fmt.Sprintf(`100 * avg by (%[1]v) (rate(node_cpu_seconds_total{mode="%[2]v",%[1]v=~%[3]v}[%[4]v]))`, c.nodeLabel, mode, nodes_regex(nodes), promql_duration(window))
+		if opts.Agg != "" {
+			// aggregate the rate within the time bucket with a subquery
+			query = over_time(opts.Agg, fmt.Sprintf("(%v)[%v:]", query, promql_duration(step)))
+		}
+		series, err := c.query_range(ctx, query, from, to, step, logger)
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting node's cpu in prometheus: %w", err)
+		}
+		for _, s := range series {
+			if cpu, ok := ret.CpuByNode[node_name(s.Metric[c.nodeLabel])]; ok {
+				if mode == "user" {
+					s.fill(cpu.User, from, step)
+				} else {
+					s.fill(cpu.System, from, step)
+				}
+			}
+		}
+	}
+	return &ret, nil
+}
+
+// node_exporter has no energy sensors of the node's components, only the energy of the whole node is returned.
+// It is the integrated power, like in GetChassisEnergy.
+func (c *Client) GetEnergyBreakdown(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*elastic.EnergyBreakdown, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	// coarse time buckets, like in the elastic backend
+	step := get_step(from, to, 15*time.Minute, util.QueryOptions{Points: 100})
+	power, err := c.GetChassisPower(ctx, nodes, from, to, util.QueryOptions{Step: step, Agg: "avg"}, logger)
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting node's energy breakdown in prometheus: %w", err)
+	}
+
+	ret := elastic.EnergyBreakdown{Time: power.Time, EnergyByNode: map[string]map[string][]float64{}}
+	ret.Warnings.Warnings = []string{"The energy of the node's components is not available, only the energy of the whole node is reported"}
+	for node_id, values := range power.PowerByNode {
+		// the value at a timestamp is the average power of the step before it
+		energy := elastic.NewSeries(len(values))
+		for idx := range values {
+			if idx+1 < len(values) && !math.IsNaN(values[idx+1]) {
+				energy[idx] = values[idx+1] * step.Seconds()
+			}
+		}
+		ret.EnergyByNode[node_id] = map[string][]float64{"node": energy}
+	}
+	return &ret, nil
+}
+
+// GetJobSummary summarizes the time series of the other queries, prometheus has no cheaper way to aggregate over the whole time window
+func (c *Client) GetJobSummary(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, logger *zerolog.Logger) (*elastic.JobSummary, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	ret := elastic.JobSummary{SummaryByNode: map[string]*elastic.NodeSummary{}}
+	for _, n := range nodes {
+		ret.SummaryByNode[n.Nid] = elastic.NewNodeSummary()
+	}
+
+	cpu, err := c.GetCpuData(ctx, nodes, from, to, util.QueryOptions{}, logger)
+	if err != nil {
+		return nil, err
+	}
+	for node_id, data := range cpu.CpuByNode {
+		if summary, ok := ret.SummaryByNode[node_id]; ok {
+			summary.CpuUser = elastic.SeriesStats(data.User)
+			summary.CpuSystem = elastic.SeriesStats(data.System)
+		}
+	}
+
+	memory, err := c.GetMemoryData(ctx, nodes, from, to, util.QueryOptions{Agg: "min"}, logger)
+	if err != nil {
+		return nil, err
+	}
+	for node_id, data := range memory.MemoryByNode {
+		if summary, ok := ret.SummaryByNode[node_id]; ok {
+			free := slices.DeleteFunc(slices.Clone(data.Free), math.IsNaN)
+			if len(free) > 0 {
+				summary.MinFreeMemory = slices.Min(free)
+			}
+		}
+	}
+	ret.AddSeries(cpu, memory)
+
+	utilization, err := c.GetDcgmData(ctx, nodes, from, to, "gpu_utilization", util.QueryOptions{}, logger)
+	if err != nil {
+		return nil, err
+	}
+	for node_id, gpus := range utilization.MetricByNode {
+		if summary, ok := ret.SummaryByNode[node_id]; ok {
+			for _, gpu := range gpus {
+				summary.Gpu(gpu.GpuIndex).Utilization = elastic.SeriesStats(gpu.Data)
+			}
+		}
+	}
+
+	temperature, err := c.GetGpuTemperature(ctx, nodes, from, to, util.QueryOptions{}, logger)
+	if err != nil {
+		return nil, err
+	}
+	for node_id, gpus := range temperature.Temperatures {
+		if summary, ok := ret.SummaryByNode[node_id]; ok {
+			for _, gpu := range gpus {
+				summary.Gpu(gpu.GpuIndex).MaxTemperature = elastic.SeriesStats(gpu.Temperatures).Max
+			}
+		}
+	}
+
+	power, err := c.GetChassisPower(ctx, nodes, from, to, util.QueryOptions{}, logger)
+	if err != nil {
+		return nil, err
+	}
+	for node_id, data := range power.PowerByNode {
+		if summary, ok := ret.SummaryByNode[node_id]; ok {
+			summary.Power = elastic.SeriesStats(data)
+			summary.Energy = elastic.SeriesStats(integrate(power.Time, data)).Max
+		}
+	}
+	return &ret, nil
+}
+
+// the node_exporter network metrics of the Slingshot interfaces (hsn*), key==counter name as in the elastic backend
+// node_exporter has no stall counters, therefore `stalls` is not provided
+var networkMetrics = map[string]string{
+	"tx_bytes":    "node_network_transmit_bytes_total",
+	"rx_bytes":    "node_network_receive_bytes_total",
+	"tx_packets":  "node_network_transmit_packets_total",
+	"rx_packets":  "node_network_receive_packets_total",
+	"link_errors": "node_network_receive_errs_total",
+}
+
+func (c *Client) GetNetworkData(ctx context.Context, nodes []util.Node, from time.Time, to time.Time, opts util.QueryOptions, logger *zerolog.Logger) (*elastic.NetworkData, error) {
+	if logger == nil {
+		logger = logging.Get()
+	}
+
+	step := get_step(from, to, 30*time.Second, opts)
+	window := max(step, time.Minute)
+	ret := elastic.NetworkData{Time: timeline(from, to, step), CountersByNode: map[string]map[string][]float64{}}
+	for _, n := range nodes {
+		ret.CountersByNode[n.Nid] = map[string][]float64{}
+	}
+	for counter, metric_name := range networkMetrics {
+		query := fmt.Sprintf(`sum by (%[1]v) (rate(%[2]v{device=~"hsn.*",%[1]v=~%[3]v}[%[4]v]))`, c.nodeLabel, metric_name, nodes_regex(nodes), promql_duration(window))
+		series, err := c.query_range(ctx, query, from, to, step, logger)
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting node's network counters in prometheus: %w", err)
+		}
+		for _, counters := range ret.CountersByNode {
+			counters[counter] = elastic.NewSeries(len(ret.Time))
+		}
+		for _, s := range series {
+			if counters, ok := ret.CountersByNode[node_name(s.Metric[c.nodeLabel])]; ok {
+				s.fill(counters[counter], from, step)
+			}
+		}
+	}
+	return &ret, nil
+}
+
+// one series of a prometheus range vector
+type series struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]any          `json:"values"` // [<unix time as float>, "<value as string>"]
+}
+
+// write the series values into `data`, at the index of their bucket on the timeline starting at `from`
+func (s series) fill(data []float64, from time.Time, step time.Duration) {
+	for _, v := range s.Values {
+		ts, ok := v[0].(float64)
+		if !ok {
+			continue
+		}
+		idx := int(math.Round((ts - float64(from.Unix())) / step.Seconds()))
+		if idx < 0 || idx >= len(data) {
+			continue
+		}
+		if value, err := strconv.ParseFloat(fmt.Sprint(v[1]), 64); err == nil && !math.IsNaN(value) {
+			data[idx] = value
+		}
+	}
+}
+
+func (c *Client) query_range(ctx context.Context, query string, from time.Time, to time.Time, step time.Duration, logger *zerolog.Logger) ([]series, error) {
+	if points := len(timeline(from, to, step)); points > max_points_per_series {
+		return nil, fmt.Errorf("The time window has %v time buckets, but at most %v can be queried. Select a larger `step` or fewer `points` - %w", points, max_points_per_series, util.ErrInvalidInput)
+	}
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(from.Unix(), 10))
+	params.Set("end", strconv.FormatInt(to.Unix(), 10))
+	params.Set("step", promql_duration(step))
+
+	var ret struct {
+		ResultType string   `json:"resultType"`
+		Result     []series `json:"result"`
+	}
+	if err := c.request(ctx, "api/v1/query_range", params, &ret, logger); err != nil {
+		return nil, err
+	}
+	if ret.ResultType != "matrix" {
+		return nil, fmt.Errorf("Prometheus returned resultType=%v, expected a matrix", ret.ResultType)
+	}
+	return ret.Result, nil
+}
+
+// send the request to the prometheus HTTP API and unmarshal the `data` field of the response into `data`
+// the parameters are sent form-encoded in the body, because the node list can make them too long for a URL
+func (c *Client) request(ctx context.Context, endpoint string, params url.Values, data any, logger *zerolog.Logger) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	headers := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	if c.authorization != "" {
+		headers["Authorization"] = c.authorization
+	}
+	resp, err := util.DoRequest(ctx, "POST", fmt.Sprintf("%v/%v", c.baseURL, endpoint), headers, []byte(params.Encode()))
+	if err != nil {
+		return err
+	}
+	if err := util.CheckResponse(resp); err != nil {
+		return err
+	}
+	logger.Debug().Msgf("Prometheus request to %v with params=%v took %vms", endpoint, params, time.Since(start).Milliseconds())
+
+	var ret struct {
+		Status string          `json:"status"`
+		Error  string          `json:"error"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(resp.ResponseData, &ret); err != nil {
+		return fmt.Errorf("Failed json unpacking of prometheus response: %w", err)
+	}
+	if ret.Status != "success" {
+		return fmt.Errorf("Prometheus request failed with status=%v error=%v", ret.Status, ret.Error)
+	}
+	if err := json.Unmarshal(ret.Data, data); err != nil {
+		return fmt.Errorf("Failed json unpacking of prometheus response data: %w", err)
+	}
+	return nil
+}
+
+// helper functions
+// get the step of a range query, analogous to the elastic interval
+func get_step(from, to time.Time, min_interval time.Duration, opts util.QueryOptions) time.Duration {
+	interval_sec := math.Ceil(to.Sub(from).Seconds() / wanted_num_timestamps)
+	if opts.Step > 0 {
+		interval_sec = math.Ceil(opts.Step.Seconds())
+	} else if opts.Points > 0 {
+		interval_sec = math.Ceil(to.Sub(from).Seconds() / float64(opts.Points))
+	}
+	return max(time.Duration(interval_sec)*time.Second, min_interval.Round(time.Second))
+}
+
+// apply the aggregation (see util.Aggregations) to a range vector selector or subquery
+func over_time(agg string, rangeVector string) string {
+	switch agg {
+	case "p95":
+		return fmt.Sprintf("quantile_over_time(0.95, %v)", rangeVector)
+	default:
+		return fmt.Sprintf("%v_over_time(%v)", agg, rangeVector)
+	}
+}
+
+// the evaluation timestamps of a range query with the given step, i.e. from, from+step, ... <= to
+func timeline(from, to time.Time, step time.Duration) []time.Time {
+	ret := []time.Time{}
+	for t := time.Unix(from.Unix(), 0); !t.After(to); t = t.Add(step) {
+		ret = append(ret, t)
+	}
+	return ret
+}
+
+func promql_duration(d time.Duration) string {
+	return fmt.Sprintf("%vs", int64(d.Seconds()))
+}
+
+// a quoted PromQL regex matching any of the nodes, optionally followed by a port or domain (e.g. nid001234:9100)
+func nodes_regex(nodes []util.Node) string {
+	nids := []string{}
+	for _, n := range nodes {
+		nids = append(nids, regexp.QuoteMeta(n.Nid))
+	}
+	return strconv.Quote(fmt.Sprintf("(%v)([.:].*)?", strings.Join(nids, "|")))
+}
+
+// strip port and domain from a node label value
+func node_name(label string) string {
+	if idx := strings.IndexAny(label, ".:"); idx != -1 {
+		return label[:idx]
+	}
+	return label
+}
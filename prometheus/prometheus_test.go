@@ -0,0 +1,198 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cscs.ch/hpcdata/util"
+)
+
+var from = time.Unix(1700000000, 0)
+
+var nop = zerolog.Nop()
+
+// a prometheus HTTP API answering every request with `body`, the form of the last request is kept in `form`
+func stub(t *testing.T, status int, body func(query string) string) (*Client, map[string]string) {
+	form := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v1/query_range" {
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed parsing form: %v", err)
+		}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body(r.PostForm.Get("query")))
+	}))
+	t.Cleanup(server.Close)
+	return NewClient(util.PrometheusConfig{URL: server.URL + "/"}, time.Minute), form
+}
+
+func matrix(result string) string {
+	return fmt.Sprintf(`{"status":"success","data":{"resultType":"matrix","result":[%v]}}`, result)
+}
+
+func equal(a, b []float64) bool {
+	return slices.EqualFunc(a, b, func(x, y float64) bool { return x == y || math.IsNaN(x) && math.IsNaN(y) })
+}
+
+func TestQueryRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		metrics int
+		err     string
+	}{
+		{"matrix", http.StatusOK, matrix(`{"metric":{"instance":"nid001"},"values":[[1700000000,"1"]]},{"metric":{"instance":"nid002"},"values":[]}`), 2, ""},
+		{"empty matrix", http.StatusOK, matrix(""), 0, ""},
+		{"vector", http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[]}}`, 0, "resultType=vector"},
+		{"error status", http.StatusOK, `{"status":"error","error":"parse error"}`, 0, "parse error"},
+		{"http error", http.StatusBadRequest, `{"status":"error","error":"bad_data"}`, 0, "400"},
+		{"invalid json", http.StatusOK, `not json`, 0, "json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, form := stub(t, tt.status, func(string) string { return tt.body })
+			series, err := c.query_range(context.Background(), "up", from, from.Add(time.Hour), 90*time.Second, &nop)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(series) != tt.metrics {
+				t.Errorf("got %v series, expected %v", len(series), tt.metrics)
+			}
+			expected := map[string]string{"query": "up", "start": "1700000000", "end": "1700003600", "step": "90s"}
+			for k, v := range expected {
+				if form[k] != v {
+					t.Errorf("%v=%v, expected %v", k, form[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestSeriesFill(t *testing.T) {
+	nan := math.NaN()
+	start := float64(from.Unix())
+	tests := []struct {
+		name     string
+		values   [][2]any
+		expected []float64
+	}{
+		{"aligned", [][2]any{{start, "1"}, {start + 60, "2"}, {start + 120, "3"}}, []float64{1, 2, 3}},
+		{"gaps are kept", [][2]any{{start + 60, "2"}}, []float64{nan, 2, nan}},
+		{"rounded to the nearest bucket", [][2]any{{start + 29, "1"}, {start + 61, "2"}}, []float64{1, 2, nan}},
+		{"outside the timeline", [][2]any{{start - 60, "1"}, {start + 180, "4"}}, []float64{nan, nan, nan}},
+		{"invalid values", [][2]any{{start, "NaN"}, {start + 60, "x"}, {"1700000120", "3"}}, []float64{nan, nan, nan}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []float64{nan, nan, nan}
+			series{Values: tt.values}.fill(data, from, time.Minute)
+			if !equal(data, tt.expected) {
+				t.Errorf("got %v, expected %v", data, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetCpuData(t *testing.T) {
+	start := from.Unix()
+	c, form := stub(t, http.StatusOK, func(query string) string {
+		value := "10"
+		if strings.Contains(query, `mode="system"`) {
+			value = "5"
+		}
+		return matrix(fmt.Sprintf(`{"metric":{"instance":"nid001:9100"},"values":[[%v,"%[3]v"],[%[2]v,"%[3]v"]]},{"metric":{"instance":"nid999"},"values":[[%[1]v,"1"]]}`, start, start+60, value))
+	})
+	nodes := []util.Node{{Nid: "nid001"}, {Nid: "nid002"}}
+	missing := math.NaN()
+
+	tests := []struct {
+		name   string
+		to     time.Time
+		opts   util.QueryOptions
+		query  string
+		step   string
+		points int
+	}{
+		{"minimum step", from.Add(3 * time.Minute), util.QueryOptions{}, `100 * avg by (instance) (rate(node_cpu_seconds_total{mode="system",instance=~"(nid001|nid002)([.:].*)?"}[60s]))`, "30s", 7},
+		{"automatic step", from.Add(5000 * time.Minute), util.QueryOptions{}, `100 * avg by (instance) (rate(node_cpu_seconds_total{mode="system",instance=~"(nid001|nid002)([.:].*)?"}[60s]))`, "60s", 5001},
+		{"step", from.Add(3 * time.Minute), util.QueryOptions{Step: time.Minute}, `100 * avg by (instance) (rate(node_cpu_seconds_total{mode="system",instance=~"(nid001|nid002)([.:].*)?"}[60s]))`, "60s", 4},
+		{"points", from.Add(3 * time.Minute), util.QueryOptions{Points: 3}, `100 * avg by (instance) (rate(node_cpu_seconds_total{mode="system",instance=~"(nid001|nid002)([.:].*)?"}[60s]))`, "60s", 4},
+		{"aggregation", from.Add(3 * time.Minute), util.QueryOptions{Step: time.Minute, Agg: "max"}, `max_over_time((100 * avg by (instance) (rate(node_cpu_seconds_total{mode="system",instance=~"(nid001|nid002)([.:].*)?"}[60s])))[60s:])`, "60s", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := c.GetCpuData(context.Background(), nodes, from, tt.to, tt.opts, &nop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if form["query"] != tt.query || form["step"] != tt.step {
+				t.Errorf("query=%v step=%v, expected query=%v step=%v", form["query"], form["step"], tt.query, tt.step)
+			}
+			if len(cpu.Time) != tt.points {
+				t.Fatalf("got %v time buckets, expected %v", len(cpu.Time), tt.points)
+			}
+			if len(cpu.CpuByNode) != 2 {
+				t.Fatalf("got nodes %v, expected nid001 and nid002", cpu.CpuByNode)
+			}
+			every := int(time.Minute / get_step(from, tt.to, 30*time.Second, tt.opts))
+			user, system := slices.Repeat([]float64{missing}, tt.points), slices.Repeat([]float64{missing}, tt.points)
+			user[0], user[every], system[0], system[every] = 10, 10, 5, 5
+			if !equal(cpu.CpuByNode["nid001"].User, user) || !equal(cpu.CpuByNode["nid001"].System, system) {
+				t.Errorf("nid001 user=%v system=%v, expected user=%v system=%v", cpu.CpuByNode["nid001"].User[:3], cpu.CpuByNode["nid001"].System[:3], user[:3], system[:3])
+			}
+			empty := slices.Repeat([]float64{missing}, tt.points)
+			if !equal(cpu.CpuByNode["nid002"].User, empty) || !equal(cpu.CpuByNode["nid002"].System, empty) {
+				t.Errorf("nid002 without data has user=%v system=%v", cpu.CpuByNode["nid002"].User[:3], cpu.CpuByNode["nid002"].System[:3])
+			}
+		})
+	}
+}
+
+func TestNotSupported(t *testing.T) {
+	c := NewClient(util.PrometheusConfig{URL: "http://localhost"}, time.Minute)
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"GetJob", func() error { _, err := c.GetJob(ctx, "1234", "daint", &nop); return err }},
+		{"ListJobs", func() error { _, err := c.ListJobs(ctx, "daint", util.JobFilter{}, &nop); return err }},
+		{"GetGlobalFilesystem", func() error {
+			_, err := c.GetGlobalFilesystem(ctx, "scratch", from, from.Add(time.Hour), util.QueryOptions{}, &nop)
+			return err
+		}},
+		{"GetJobFilesystem", func() error {
+			_, err := c.GetJobFilesystem(ctx, "scratch", "1234", from, from.Add(time.Hour), util.QueryOptions{}, &nop)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, util.ErrNotSupported) {
+				t.Errorf("expected util.ErrNotSupported, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The carbon intensity of the electricity, valid from `From` until the next entry of the profile
+type CarbonIntensity struct {
+	From      time.Time
+	Intensity float64 // gCO2eq/kWh
+}
+
+// CarbonProfile is sorted by time, a profile with a single entry is a constant intensity
+type CarbonProfile []CarbonIntensity
+
+// ReadCarbonProfile reads a CSV file with the columns `<RFC3339 timestamp>,<gCO2eq/kWh>`.
+// Empty lines, lines starting with # and a header line are skipped.
+func ReadCarbonProfile(path string) (CarbonProfile, error) {
+	ret := CarbonProfile{}
+	err := read_two_column_csv(path, "carbon intensity profile", func(timestamp, intensity string) error {
+		from, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return errInvalidKey
+		}
+		value, err := strconv.ParseFloat(intensity, 64)
+		if err != nil || value < 0 {
+			return fmt.Errorf("invalid carbon intensity")
+		}
+		ret = append(ret, CarbonIntensity{from, value})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(ret, func(a, b CarbonIntensity) int { return a.From.Compare(b.From) })
+	return ret, nil
+}
+
+// At returns the carbon intensity at time t, before the first entry the first entry's intensity is used
+func (p CarbonProfile) At(t time.Time) float64 {
+	idx, found := slices.BinarySearchFunc(p, t, func(ci CarbonIntensity, t time.Time) int { return ci.From.Compare(t) })
+	if !found && idx > 0 {
+		idx--
+	}
+	return p[idx].Intensity
+}
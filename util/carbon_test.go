@@ -0,0 +1,69 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadCarbonProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected CarbonProfile
+		err      bool
+	}{
+		{"header", "time,intensity\n2024-01-01T00:00:00Z,100\n", CarbonProfile{{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 100}}, false},
+		{"sorted by time", "2024-01-01T01:00:00Z,50\n2024-01-01T00:00:00Z,100\n", CarbonProfile{{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 100}, {time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC), 50}}, false},
+		{"time zone", "2024-01-01T01:00:00+01:00,100\n", CarbonProfile{{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 100}}, false},
+		{"invalid timestamp", "2024-01-01T00:00:00Z,100\n2024-01-01,50\n", nil, true},
+		{"invalid intensity", "2024-01-01T00:00:00Z,high\n", nil, true},
+		{"negative intensity", "2024-01-01T00:00:00Z,-1\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := ReadCarbonProfile(write_file(t, tt.content))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", profile)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(profile) != len(tt.expected) {
+				t.Fatalf("got %v, expected %v", profile, tt.expected)
+			}
+			for idx := range profile {
+				if !profile[idx].From.Equal(tt.expected[idx].From) || profile[idx].Intensity != tt.expected[idx].Intensity {
+					t.Errorf("got %v, expected %v", profile, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestCarbonProfileAt(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	profile := CarbonProfile{{start, 100}, {start.Add(time.Hour), 50}, {start.Add(2 * time.Hour), 200}}
+	tests := []struct {
+		name     string
+		profile  CarbonProfile
+		t        time.Time
+		expected float64
+	}{
+		{"before the first entry", profile, start.Add(-time.Hour), 100},
+		{"at the first entry", profile, start, 100},
+		{"between entries", profile, start.Add(90 * time.Minute), 50},
+		{"at an entry", profile, start.Add(time.Hour), 50},
+		{"after the last entry", profile, start.Add(24 * time.Hour), 200},
+		{"constant", CarbonProfile{{start, 42}}, start.Add(-time.Hour), 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.profile.At(tt.t); got != tt.expected {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
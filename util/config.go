@@ -1,10 +1,13 @@
 package util
 
 import (
+	"cmp"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -27,27 +30,129 @@ type ElasticConfig struct {
 	URL      string `yaml:"url"`
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	// search.max_buckets of the cluster, defaults to 65536
+	MaxBuckets int `yaml:"max_buckets"`
+}
+type PrometheusConfig struct {
+	URL          string `yaml:"url"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	NodeLabel    string `yaml:"node_label"`     // label carrying the node name in node_exporter metrics, default "instance"
+	GpuNodeLabel string `yaml:"gpu_node_label"` // label carrying the node name in dcgm-exporter metrics, default "Hostname"
+	PowerMetric  string `yaml:"power_metric"`   // metric with the node's power in Watt, default "node_hwmon_power_average_watt"
 }
 type SecurityConfig struct {
 	AllowAnyJob []string `yaml:"allow_any_job"`
 }
 type ClusterConfig struct {
-	Name        string `yaml:"name"`
-	F7tURL      string `yaml:"f7t_url"`
-	ElasticName string `yaml:"elastic_name"`
+	Name        string           `yaml:"name"`
+	F7tURL      string           `yaml:"f7t_url"`
+	ElasticName string           `yaml:"elastic_name"`
+	Backend     string           `yaml:"backend"`     // which metrics backend serves this cluster, empty defaults to "elastic"
+	Filesystems []string         `yaml:"filesystems"` // filesystems with global stats, e.g. capstor, iopsstor. Empty defaults to capstor
+	Prometheus  PrometheusConfig `yaml:"prometheus"`
+	// optional CSV file with the carbon intensity of the cluster's electricity, see ReadCarbonProfile
+	CarbonIntensityFile string        `yaml:"carbon_intensity_file"`
+	CarbonProfile       CarbonProfile `yaml:"-"`
+	NodeMemory          float64       `yaml:"node_memory"` // memory of a compute node in GiB, needed by the memory diagnostics
+	// optional CSV file mapping the nids of the cluster to xnames, see ReadInventory
+	InventoryFile string     `yaml:"inventory_file"`
+	Inventory     *Inventory `yaml:"-"`
+}
+
+// TimeoutConfig holds the timeout of a single call to each upstream service.
+// A request hitting a timeout is answered with 504 Gateway Timeout.
+type TimeoutConfig struct {
+	Elastic    time.Duration `yaml:"elastic"`    // Elasticsearch and OpenSearch, default 60s
+	Prometheus time.Duration `yaml:"prometheus"` // default 60s
+	Firecrest  time.Duration `yaml:"firecrest"`  // default 30s
+	Redis      time.Duration `yaml:"redis"`      // default 2s
+	Database   time.Duration `yaml:"db"`         // default 10s
+}
+
+// A diagnostics rule, Threshold and Fraction are rule specific, see DiagnosticsConfig
+type DiagnosticRule struct {
+	Disabled  bool    `yaml:"disabled"`
+	Threshold float64 `yaml:"threshold"`
+	Fraction  float64 `yaml:"fraction"` // fraction of the runtime during which the condition must hold
+	Severity  string  `yaml:"severity"` // info, warning or critical
 }
+
+// DiagnosticsConfig holds the rules evaluated at /metrics/{system_name}/{job_id}/diagnostics
+type DiagnosticsConfig struct {
+	GpuIdle         DiagnosticRule `yaml:"gpu_idle"`         // GPU utilization below threshold (%), default 5%, 30% of the runtime
+	CpuImbalance    DiagnosticRule `yaml:"cpu_imbalance"`    // a node's CPU usage exceeds the median of the other nodes by threshold (percentage points), default 80, 30% of the runtime
+	MemoryUnderused DiagnosticRule `yaml:"memory_underused"` // used memory never above threshold (% of node_memory), default 10%
+	GpuTemperature  DiagnosticRule `yaml:"gpu_temperature"`  // GPU temperature at or above threshold (°C), default 85°C, any time
+}
+
+// DiagnosticSeverities are ordered by increasing severity
+var DiagnosticSeverities = []string{"info", "warning", "critical"}
+
 type RedisConfig struct {
 	Address  string `yaml:"address"`
 	Password string `yaml:"password"`
 }
+
+// A metric declared in the config file, for which the endpoint
+// /metrics/{system_name}/{job_id}[/{node_id}]/<path> is registered automatically
+type CatalogMetric struct {
+	Path          string            `yaml:"path"`            // e.g. node/swap
+	Name          string            `yaml:"name"`            // key of the values in the JSON response, default last element of path
+	Index         string            `yaml:"index"`           // index pattern to search in
+	Filter        map[string]string `yaml:"filter"`          // term filters, key==field, value==value that must match
+	ValueField    string            `yaml:"value_field"`     // field holding the value
+	NodeField     string            `yaml:"node_field"`      // field holding the node id
+	NodeIdNumeric bool              `yaml:"node_id_numeric"` // true if node_field stores the number only (e.g. 1234 instead of nid001234)
+	SplitField    string            `yaml:"split_field"`     // optional, e.g. the GPU index, one series per node and split value
+	Aggregation   string            `yaml:"aggregation"`     // max, min, avg or sum
+	Unit          string            `yaml:"unit"`
+	MinInterval   time.Duration     `yaml:"min_interval"` // e.g. 30s
+}
+
+var catalogAggregations = []string{"max", "min", "avg", "sum", "p95", "last"}
+
+// the built-in routes below /metrics/{system_name}/{job_id}/, which catalog metrics must not shadow or be shadowed by.
+// Keep in sync with the routes registered in hpcdata.go
+var builtinMetricRoutes = []string{
+	"capstor/global", "fs/{filesystem}/global", "fs/{filesystem}/job",
+	"gpu", "gpu/{dcgm_metric}", "gpu/{dcgm_metric}/stream",
+	"node/memory", "node/memory/stream", "node/cpu", "node/cpu/stream", "node/energy",
+	"node/power", "node/power/stream", "node/network", "node/network/stream",
+	"energy/report", "diagnostics", "summary", "custom",
+	"{node_id}/gpu", "{node_id}/gpu/{dcgm_metric}", "{node_id}/gpu/{dcgm_metric}/stream",
+	"{node_id}/node/memory", "{node_id}/node/memory/stream", "{node_id}/node/cpu", "{node_id}/node/cpu/stream", "{node_id}/node/energy",
+	"{node_id}/node/power", "{node_id}/node/power/stream", "{node_id}/node/network", "{node_id}/node/network/stream",
+	"{node_id}/energy/report", "{node_id}/summary", "{node_id}/custom",
+}
+
+// whether a request path can match both routes, a segment in braces matches any segment
+func routesOverlap(a, b string) bool {
+	a_segments, b_segments := strings.Split(a, "/"), strings.Split(b, "/")
+	if len(a_segments) != len(b_segments) {
+		return false
+	}
+	is_var := func(segment string) bool { return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") }
+	for idx := range a_segments {
+		if a_segments[idx] != b_segments[idx] && !is_var(a_segments[idx]) && !is_var(b_segments[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
 type Config struct {
-	Server       ServerConfig    `yaml:"server"`
-	Database     DatabaseConfig  `yaml:"db"`
-	Elastic      ElasticConfig   `yaml:"elastic"`
-	OauthSigners []string        `yaml:"openid"`
-	Clusters     []ClusterConfig `yaml:"clusters"`
-	Security     SecurityConfig  `yaml:"security"`
-	RedisConfig  RedisConfig     `yaml:"redis"`
+	Server       ServerConfig      `yaml:"server"`
+	Database     DatabaseConfig    `yaml:"db"`
+	Elastic      ElasticConfig     `yaml:"elastic"`
+	OpenSearch   ElasticConfig     `yaml:"opensearch"`
+	OauthSigners []string          `yaml:"openid"`
+	Clusters     []ClusterConfig   `yaml:"clusters"`
+	Security     SecurityConfig    `yaml:"security"`
+	RedisConfig  RedisConfig       `yaml:"redis"`
+	Catalog      []CatalogMetric   `yaml:"metric_catalog"`
+	Timeouts     TimeoutConfig     `yaml:"timeouts"`
+	Diagnostics  DiagnosticsConfig `yaml:"diagnostics"`
 }
 
 func ReadConfig(path string) *Config {
@@ -64,6 +169,9 @@ func ReadConfig(path string) *Config {
 	if envvar, ok := os.LookupEnv("ELASTIC_PASSWORD"); ok {
 		config.Elastic.Password = envvar
 	}
+	if envvar, ok := os.LookupEnv("OPENSEARCH_PASSWORD"); ok {
+		config.OpenSearch.Password = envvar
+	}
 	if envvar, ok := os.LookupEnv("HPCDATA_DATABASE_PASSWORD"); ok {
 		config.Database.Password = envvar
 	}
@@ -78,11 +186,47 @@ func ReadConfig(path string) *Config {
 		config.Database.Name == "" {
 		log.Fatalf("Database config section does not pass sanity checks. URL, Username and Password must all not be empty")
 	}
-	if config.Elastic.URL == "" ||
+	uses_elastic := len(config.Clusters) == 0
+	uses_opensearch := false
+	for idx := range config.Clusters {
+		cc := &config.Clusters[idx]
+		if len(cc.Filesystems) == 0 {
+			cc.Filesystems = []string{"capstor"}
+		}
+		for fsidx := range cc.Filesystems {
+			cc.Filesystems[fsidx] = strings.ToLower(cc.Filesystems[fsidx])
+		}
+		if cc.CarbonIntensityFile != "" {
+			if cc.CarbonProfile, err = ReadCarbonProfile(cc.CarbonIntensityFile); err != nil {
+				log.Fatalf("Carbon intensity profile of cluster=%v does not pass sanity checks. err=%v", cc.Name, err)
+			}
+		}
+		if cc.InventoryFile != "" {
+			if cc.Inventory, err = ReadInventory(cc.InventoryFile); err != nil {
+				log.Fatalf("Node inventory of cluster=%v does not pass sanity checks. err=%v", cc.Name, err)
+			}
+		}
+		switch cc.Backend {
+		case "", "elastic":
+			uses_elastic = true
+		case "opensearch":
+			uses_opensearch = true
+		case "prometheus":
+			if cc.Prometheus.URL == "" {
+				log.Fatalf("Prometheus config section of cluster=%v does not pass sanity checks. URL must not be empty", cc.Name)
+			}
+		}
+	}
+	if uses_elastic && (config.Elastic.URL == "" ||
 		config.Elastic.Username == "" ||
-		config.Elastic.Password == "" {
+		config.Elastic.Password == "") {
 		log.Fatalf("Elastic config section does not pass sanity checks. URL, Username and Password must all not be empty")
 	}
+	if uses_opensearch && (config.OpenSearch.URL == "" ||
+		config.OpenSearch.Username == "" ||
+		config.OpenSearch.Password == "") {
+		log.Fatalf("OpenSearch config section does not pass sanity checks. URL, Username and Password must all not be empty")
+	}
 
 	if len(config.OauthSigners) == 0 {
 		log.Fatalf("OpenId config section does not pass sanity checks. It must contain at least one certificates URL which signs JWTs")
@@ -92,6 +236,54 @@ func ReadConfig(path string) *Config {
 		log.Fatalf("Redis config section does not pass sanity checks. It must contain the Address and Password")
 	}
 
+	config.Timeouts.Elastic = cmp.Or(config.Timeouts.Elastic, 60*time.Second)
+	config.Timeouts.Prometheus = cmp.Or(config.Timeouts.Prometheus, 60*time.Second)
+	config.Timeouts.Firecrest = cmp.Or(config.Timeouts.Firecrest, 30*time.Second)
+	config.Timeouts.Redis = cmp.Or(config.Timeouts.Redis, 2*time.Second)
+	config.Timeouts.Database = cmp.Or(config.Timeouts.Database, 10*time.Second)
+
+	diagnostic_defaults := []struct {
+		rule      *DiagnosticRule
+		threshold float64
+		fraction  float64
+		severity  string
+	}{
+		{&config.Diagnostics.GpuIdle, 5, 0.3, "warning"},
+		{&config.Diagnostics.CpuImbalance, 80, 0.3, "warning"},
+		{&config.Diagnostics.MemoryUnderused, 10, 0, "info"},
+		{&config.Diagnostics.GpuTemperature, 85, 0, "warning"},
+	}
+	for _, d := range diagnostic_defaults {
+		d.rule.Threshold = cmp.Or(d.rule.Threshold, d.threshold)
+		d.rule.Fraction = cmp.Or(d.rule.Fraction, d.fraction)
+		d.rule.Severity = cmp.Or(d.rule.Severity, d.severity)
+		if !slices.Contains(DiagnosticSeverities, d.rule.Severity) || d.rule.Fraction < 0 || d.rule.Fraction > 1 {
+			log.Fatalf("Diagnostics config section does not pass sanity checks. severity must be one of %v and fraction between 0 and 1, rule=%+v", DiagnosticSeverities, *d.rule)
+		}
+	}
+
+	for idx := range config.Catalog {
+		m := &config.Catalog[idx]
+		m.Path = strings.Trim(m.Path, "/")
+		if m.Path == "" || m.Index == "" || m.ValueField == "" || m.NodeField == "" {
+			log.Fatalf("Metric catalog entry %v does not pass sanity checks. path, index, value_field and node_field must all not be empty", idx)
+		}
+		for _, route := range builtinMetricRoutes {
+			if routesOverlap(m.Path, route) || routesOverlap("{node_id}/"+m.Path, route) {
+				log.Fatalf("Metric catalog entry %v collides with the built-in route /metrics/{system_name}/{job_id}/%v. Choose another path", m.Path, route)
+			}
+		}
+		if !slices.Contains(catalogAggregations, m.Aggregation) {
+			log.Fatalf("Metric catalog entry %v has an invalid aggregation=%v. Must be one of %v", m.Path, m.Aggregation, catalogAggregations)
+		}
+		if m.Name == "" {
+			m.Name = m.Path[strings.LastIndex(m.Path, "/")+1:]
+		}
+		if m.MinInterval == 0 {
+			m.MinInterval = 30 * time.Second
+		}
+	}
+
 	return &config
 }
 
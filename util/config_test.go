@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestRoutesOverlap(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"node/cpu", "node/cpu", true},
+		{"node/swap", "node/cpu", false},
+		{"gpu/power", "gpu/{dcgm_metric}", true},
+		{"{node_id}/power/stream", "gpu/{dcgm_metric}/stream", true},
+		{"{node_id}/cpu", "node/cpu", true},
+		{"x/summary", "{node_id}/summary", true},
+		{"node/cpu/user", "node/cpu", false},
+		{"summary", "{node_id}/summary", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := routesOverlap(tt.a, tt.b); got != tt.expected {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errInvalidKey is returned by the parse function of read_two_column_csv if the first column of a record is invalid.
+// In the first record it marks a header line.
+var errInvalidKey = errors.New("invalid first column")
+
+// read_two_column_csv reads a CSV file with two columns and calls parse with the trimmed columns of every record.
+// Empty lines, lines starting with # and a header line are skipped. `what` names the file in errors.
+func read_two_column_csv(path, what string, parse func(first, second string) error) error {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records := 0
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Failed reading %v %v: %w", what, path, err)
+		}
+		err = parse(strings.TrimSpace(record[0]), strings.TrimSpace(record[1]))
+		if errors.Is(err, errInvalidKey) && line == 1 {
+			// header line
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Invalid entry in %v %v, record=%v: %w", what, path, record, err)
+		}
+		records++
+	}
+	if records == 0 {
+		return fmt.Errorf("The %v %v is empty", what, path)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadTwoColumnCSV(t *testing.T) {
+	// a key is a single letter
+	parse := func(records *[][2]string) func(first, second string) error {
+		return func(first, second string) error {
+			if len(first) != 1 {
+				return errInvalidKey
+			}
+			if second == "invalid" {
+				return errors.New("invalid value")
+			}
+			*records = append(*records, [2]string{first, second})
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		expected [][2]string
+		err      bool
+	}{
+		{"header", "key,value\na,1\n", [][2]string{{"a", "1"}}, false},
+		{"no header", "a,1\nb, 2\n", [][2]string{{"a", "1"}, {"b", "2"}}, false},
+		{"trimmed", " a , 1 \n", [][2]string{{"a", "1"}}, false},
+		{"comments and empty lines", "# comment\n\nkey,value\n\na,1\n# end\n", [][2]string{{"a", "1"}}, false},
+		{"only the first record can be a header", "a,1\nkey,value\n", nil, true},
+		{"invalid value", "a,invalid\n", nil, true},
+		{"invalid value in the first record", "key,value\na,1\nb,invalid\n", nil, true},
+		{"missing column", "a\n", nil, true},
+		{"too many columns", "a,1,2\n", nil, true},
+		{"only a header", "key,value\n", nil, true},
+		{"only comments", "# a,1\n", nil, true},
+		{"empty", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := [][2]string{}
+			err := read_two_column_csv(write_file(t, tt.content), "test file", parse(&records))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", records)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(records, tt.expected) {
+				t.Errorf("got %v, expected %v", records, tt.expected)
+			}
+		})
+	}
+
+	if err := read_two_column_csv(filepath.Join(t.TempDir(), "missing.csv"), "test file", parse(&[][2]string{})); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
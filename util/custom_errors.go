@@ -5,4 +5,5 @@ import (
 )
 
 var ErrInvalidInput = errors.New("invalid input")
+var ErrNotSupported = errors.New("not supported by the backend")
 
@@ -1,20 +1,23 @@
 package util
 
 import (
+    "context"
     "database/sql"
+    "time"
 
     "cscs.ch/hpcdata/logging"
 )
 
 type DB struct {
-	db *sql.DB
+	db      *sql.DB
+	timeout time.Duration // of a single query
 }
 
-func NewDb(dbpath string) DB {
+func NewDb(dbpath string, timeout time.Duration) DB {
 	logger := logging.Get()
 	logger.Debug().Msgf("Using database at path %v", dbpath)
 	var err error
-	var db DB
+	db := DB{timeout: timeout}
 	db.db, err = sql.Open("mysql", dbpath)
 	if err != nil {
 		logger.Error().Err(err).Msg("Error opening database")
@@ -23,22 +26,26 @@ func NewDb(dbpath string) DB {
 	return db
 }
 
-func (db DB) PushMetricData(timestamp int64, jobid, name, value, xname, node, context, cluster string) bool {
-    res, err := db.db.Exec("insert into userdata (`timestamp`, jobid, name, value, xname, node, context, cluster) values (?,?,?,?,?,?,?,?)", timestamp, jobid, name, value, xname, node, context, cluster)
+func (db DB) PushMetricData(ctx context.Context, timestamp int64, jobid, name, value, xname, node, metric_context, cluster string) bool {
+    ctx, cancel := context.WithTimeout(ctx, db.timeout)
+    defer cancel()
+    res, err := db.db.ExecContext(ctx, "insert into userdata (`timestamp`, jobid, name, value, xname, node, context, cluster) values (?,?,?,?,?,?,?,?)", timestamp, jobid, name, value, xname, node, metric_context, cluster)
     if err != nil {
-        logging.Errorf(err, "Failed adding userdata, timestamp=%v, jobid=%v, name=%v, value=%v, xname=%v, node=%v, context=%v, cluster=%v err=%v", timestamp, jobid, name, value, xname, node, context, cluster, err)
+        logging.Errorf(err, "Failed adding userdata, timestamp=%v, jobid=%v, name=%v, value=%v, xname=%v, node=%v, context=%v, cluster=%v err=%v", timestamp, jobid, name, value, xname, node, metric_context, cluster, err)
         return false
     }
     if num_changed, err := res.RowsAffected() ; err != nil {
-        logging.Errorf(err, "Failed adding userdata, timestamp=%v, jobid=%v, name=%v, value=%v, xname=%v, node=%v, context=%v, cluster=%v err=%v", timestamp, jobid, name, value, xname, node, context, cluster, err)
+        logging.Errorf(err, "Failed adding userdata, timestamp=%v, jobid=%v, name=%v, value=%v, xname=%v, node=%v, context=%v, cluster=%v err=%v", timestamp, jobid, name, value, xname, node, metric_context, cluster, err)
         return false
     } else {
         return num_changed==1
     }
 }
 
-func (db DB) GetMetricData(jobid, name, context, node, cluster string) ([]int64, []string, error) {
-	rows, err := db.db.Query("select timestamp, value from userdata where jobid=? and name=? and context=? and node=? and cluster=? order by timestamp", jobid, name, context, node, cluster)
+func (db DB) GetMetricData(ctx context.Context, jobid, name, metric_context, node, cluster string) ([]int64, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+	rows, err := db.db.QueryContext(ctx, "select timestamp, value from userdata where jobid=? and name=? and context=? and node=? and cluster=? order by timestamp", jobid, name, metric_context, node, cluster)
 	if err != nil {
 		logging.Error(err, "Failed query")
 		return nil, nil, err
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -40,8 +41,9 @@ func CheckResponse(resp *ResponseHelper) error {
 	return nil
 }
 
-func DoRequest(method string, url string, headers map[string]string, data []byte) (*ResponseHelper, error) {
-	req, err := retryablehttp.NewRequest(method, url, data)
+// the request is aborted when ctx is done, including any retries
+func DoRequest(ctx context.Context, method string, url string, headers map[string]string, data []byte) (*ResponseHelper, error) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, method, url, data)
 	if err != nil {
 		return nil, err
 	}
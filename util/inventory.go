@@ -0,0 +1,84 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// the submatches are the blade, the chassis and the cabinet
+var xnameRegex = regexp.MustCompile(`^(((x\d+)c\d+)s\d+)b\d+n\d+$`)
+
+// TopologyLevels are the levels of the physical location of a node, which are derived from its xname
+var TopologyLevels = []string{"blade", "chassis", "cabinet"}
+
+// Inventory maps the node ids (nidNNNNNN) of a cluster to their xnames (e.g. x1000c0s0b0n0) and back.
+// All methods can be called on a nil Inventory, i.e. a cluster without inventory, which knows no xnames.
+type Inventory struct {
+	xnames map[string]string // key==nid
+	nids   map[string]string // key==xname
+}
+
+// ReadInventory reads a CSV file with the columns `<nid>,<xname>`, where the nid is either a number or nidNNNNNN.
+// Empty lines, lines starting with # and a header line are skipped.
+func ReadInventory(path string) (*Inventory, error) {
+	ret := Inventory{xnames: map[string]string{}, nids: map[string]string{}}
+	err := read_two_column_csv(path, "node inventory", func(nid, xname string) error {
+		number, err := strconv.Atoi(strings.TrimPrefix(nid, "nid"))
+		if err != nil {
+			return errInvalidKey
+		}
+		if number < 0 || !xnameRegex.MatchString(xname) {
+			return fmt.Errorf("invalid nid or xname")
+		}
+		nid = fmt.Sprintf("nid%06d", number)
+		ret.xnames[nid] = xname
+		ret.nids[xname] = nid
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// Xname returns the xname of the nid, or an empty string if it is unknown
+func (inv *Inventory) Xname(nid string) string {
+	if inv == nil {
+		return ""
+	}
+	return inv.xnames[nid]
+}
+
+// Nid returns the nid of a node given either as nid or as xname. An unknown xname is returned unchanged.
+func (inv *Inventory) Nid(node_id string) string {
+	if inv == nil {
+		return node_id
+	}
+	if nid, ok := inv.nids[node_id]; ok {
+		return nid
+	}
+	return node_id
+}
+
+// Fill sets the xnames of the nodes
+func (inv *Inventory) Fill(nodes []Node) {
+	for idx := range nodes {
+		if xname := inv.Xname(nodes[idx].Nid); xname != "" {
+			nodes[idx].Xname = xname
+		}
+	}
+}
+
+// Location returns the component of the xname at the topology level, e.g. x1000c0 for the chassis of x1000c0s0b0n0.
+// It returns false if the xname is invalid.
+func Location(xname, level string) (string, bool) {
+	match := xnameRegex.FindStringSubmatch(xname)
+	idx := slices.Index(TopologyLevels, level)
+	if match == nil || idx < 0 {
+		return "", false
+	}
+	return match[idx+1], true
+}
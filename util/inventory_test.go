@@ -0,0 +1,69 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestReadInventory(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		xnames  map[string]string // key==nid
+		err     bool
+	}{
+		{"number", "1,x1000c0s0b0n0\n", map[string]string{"nid000001": "x1000c0s0b0n0"}, false},
+		{"nid", "nid000002,x1000c0s0b0n1\n", map[string]string{"nid000002": "x1000c0s0b0n1"}, false},
+		{"nid without leading zeros", "nid3,x1000c0s1b0n0\n", map[string]string{"nid000003": "x1000c0s1b0n0"}, false},
+		{"header", "nid,xname\n1,x1000c0s0b0n0\n", map[string]string{"nid000001": "x1000c0s0b0n0"}, false},
+		{"invalid xname", "1,x1000c0\n", nil, true},
+		{"negative nid", "-1,x1000c0s0b0n0\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := ReadInventory(write_file(t, tt.content))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", inv.xnames)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(inv.xnames) != len(tt.xnames) {
+				t.Errorf("got %v, expected %v", inv.xnames, tt.xnames)
+			}
+			for nid, xname := range tt.xnames {
+				if inv.Xname(nid) != xname || inv.Nid(xname) != nid {
+					t.Errorf("%v is %v, expected %v", nid, inv.Xname(nid), xname)
+				}
+			}
+		})
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		xname    string
+		level    string
+		expected string
+		ok       bool
+	}{
+		{"x1000c0s0b0n0", "blade", "x1000c0s0", true},
+		{"x1000c0s0b0n0", "chassis", "x1000c0", true},
+		{"x1000c0s0b0n0", "cabinet", "x1000", true},
+		{"x9c12s7b1n1", "blade", "x9c12s7", true},
+		{"x1000c0s0b0n0", "node", "", false},
+		{"x1000c0s0", "chassis", "", false},
+		{"nid000001", "chassis", "", false},
+		{"", "cabinet", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.xname+"/"+tt.level, func(t *testing.T) {
+			got, ok := Location(tt.xname, tt.level)
+			if got != tt.expected || ok != tt.ok {
+				t.Errorf("got %v %v, expected %v %v", got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
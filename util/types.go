@@ -1,6 +1,7 @@
 package util
 
 import (
+	"slices"
 	"time"
 )
 
@@ -15,4 +16,87 @@ type Job struct {
 	End      time.Time
 	Nodes    []Node
 	Finished bool
+
+	// Slurm metadata, the fields are empty if the source does not provide them
+	Name       string
+	User       string
+	Partition  string
+	State      string
+	ExitCode   int
+	Signal     int // the signal that terminated the job, 0 if none
+	WorkingDir string
+	TimeLimit  time.Duration // 0 if unlimited or unknown
+	Steps      []JobStep
+
+	// the allocations of a job id with more than one, i.e. the tasks of a job array, the components of a heterogeneous
+	// job or the runs of a requeued job, sorted by start time. Empty if the job has a single allocation.
+	Components []Job
+}
+
+// JobStep is a Slurm step of a job, End is the current time if the step is still running
+type JobStep struct {
+	Id       string
+	Name     string
+	State    string
+	ExitCode int
+	Start    time.Time
+	End      time.Time
+	Finished bool
+	Nodes    []Node // empty if unknown, i.e. the nodes of the job
+}
+
+// JobFilter selects the jobs of a cluster that ran at any time between From and To
+type JobFilter struct {
+	From     time.Time
+	To       time.Time
+	Accounts []string // nil means any account
+	User     string   // empty means any user
+	Exclude  []string // job ids (without array task or het job offset) that are not returned, e.g. the running jobs
+	Offset   int      // pagination, number of jobs to skip
+	Limit    int      // pagination, maximum number of jobs to return
+}
+
+// JobList is a page of the jobs matching a JobFilter, Total is the number of matching jobs over all pages
+type JobList struct {
+	Jobs  []Job
+	Total int
+}
+
+// QueryOptions are the client-selectable time resolution and aggregation of a time series query
+type QueryOptions struct {
+	Step   time.Duration // width of a time bucket, 0 means automatic
+	Points int           // wanted number of time buckets, 0 means automatic, ignored if Step is set
+	Agg    string        // aggregation of the values within a time bucket, empty means the metric's default (see Aggregations)
+}
+
+// Aggregations are the values of QueryOptions.Agg that a client can select
+var Aggregations = []string{"avg", "min", "max", "p95", "last"}
+
+// CombineJobs combines the allocations of the job id into a single job, which spans all of them
+func CombineJobs(jobid string, allocations []Job) *Job {
+	if len(allocations) == 1 {
+		return &allocations[0]
+	}
+	slices.SortStableFunc(allocations, func(a, b Job) int { return a.Start.Compare(b.Start) })
+	// the metadata is the one of the latest allocation
+	ret := allocations[len(allocations)-1]
+	ret.SlurmId = jobid
+	ret.Start = allocations[0].Start
+	ret.Nodes = []Node{}
+	ret.Steps = []JobStep{}
+	ret.Finished = true
+	ret.Components = allocations
+	for _, allocation := range allocations {
+		if allocation.End.After(ret.End) {
+			ret.End = allocation.End
+		}
+		for _, n := range allocation.Nodes {
+			if !slices.Contains(ret.Nodes, n) {
+				ret.Nodes = append(ret.Nodes, n)
+			}
+		}
+		ret.Steps = append(ret.Steps, allocation.Steps...)
+		ret.Finished = ret.Finished && allocation.Finished
+	}
+	return &ret
 }
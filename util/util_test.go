@@ -0,0 +1,16 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writes content to a temporary file and returns its path
+func write_file(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}